@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// acquire waits for the lock and prints its session, which is needed to release it.
+// The lock is not renewed after the process exits, so it lives until the session TTL at most.
+func acquire(ctx context.Context, opts lockz.BasicOptions, timeout time.Duration, key string, stdout io.Writer) (err error) {
+	locker, err := lockz.NewLocker(opts)
+	if err != nil {
+		return
+	}
+	defer locker.Close()
+
+	var handle *lockz.LockHandle
+	handle, err = lock(ctx, &locker, timeout, key)
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(stdout, handle.SessionID+"\n")
+	return
+}
+
+// status prints the lock and its holder.
+func status(ctx context.Context, opts lockz.BasicOptions, key string, stdout io.Writer) (err error) {
+	var info lockz.LockInfo
+	info, err = lockz.InspectLock(ctx, opts, key)
+	if err != nil {
+		return
+	}
+	return printJSON(stdout, info)
+}
+
+// list prints the locks under the prefix, one per line.
+func list(ctx context.Context, opts lockz.BasicOptions, prefix string, stdout io.Writer) (err error) {
+	var locks []lockz.LockInfo
+	locks, err = lockz.ListLocks(ctx, opts, prefix)
+	if err != nil {
+		return
+	}
+	for _, info := range locks {
+		err = printJSON(stdout, info)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// breakLock breaks the lock and prints the audit record.
+func breakLock(ctx context.Context, opts lockz.BasicOptions, key string, reason string, stdout io.Writer) (err error) {
+	var record lockz.AuditRecord
+	record, err = lockz.BreakLock(ctx, opts, key, reason)
+	if err != nil {
+		return
+	}
+	return printJSON(stdout, record)
+}
+
+// watch prints the holder every time the lock changes hands, until the process is interrupted.
+// An empty session means nobody holds the lock.
+func watch(ctx context.Context, opts lockz.BasicOptions, key string, stdout io.Writer) (err error) {
+	election, err := lockz.NewElection(opts, key)
+	if err != nil {
+		return
+	}
+	defer election.Close()
+	for detail := range election.Observe(ctx) {
+		err = printJSON(stdout, detail)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// execCommand holds the lock while running the command, renewing it with Extend.
+// The command is killed once the lock is lost or the process is interrupted, otherwise the exit code is the one of the command.
+// (锁丢了，就杀掉子进程)
+func execCommand(ctx context.Context, opts lockz.BasicOptions, timeout time.Duration, key string, command []string, stdout io.Writer, stderr io.Writer) (code int, err error) {
+	code = 1
+	locker, err := lockz.NewLocker(opts)
+	if err != nil {
+		return
+	}
+	defer locker.Close()
+
+	// Take the lock
+	var handle *lockz.LockHandle
+	handle, err = lock(ctx, &locker, timeout, key)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = handle.Release()
+	}()
+
+	// Renew it until the command ends, an interruption releases it
+	go func() {
+		_ = handle.ExtendContext(ctx)
+	}()
+
+	// The context of the handle is cancelled once the lock is lost or released, which kills the command
+	cmd := exec.CommandContext(handle.Context(), command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+
+	// The lock is gone before the command ended
+	if ctx.Err() != nil {
+		err = ctx.Err()
+		return
+	}
+	if handle.Context().Err() != nil {
+		err = context.Cause(handle.Context())
+		return
+	}
+
+	// Pass the exit code of the command on
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code, err = exitErr.ExitCode(), nil
+		return
+	}
+	if err == nil {
+		code = 0
+	}
+	return
+}
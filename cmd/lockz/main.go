@@ -0,0 +1,153 @@
+// Command lockz takes the distributed locks of the lockz package from the shell, so that cron jobs and scripts
+// can exclude each other without writing Go.
+//
+// Usage:
+//
+//	lockz [flags] acquire <key>                      Wait for the lock and print its session, the lock lives until the session TTL
+//	lockz [flags] release <key> <session>            Release the lock held by the session
+//	lockz [flags] status <key>                       Print the lock and its holder
+//	lockz [flags] list [prefix]                      Print the locks under the prefix, one per line
+//	lockz [flags] break <key> <reason>               Break a stuck lock, leaving an audit record
+//	lockz [flags] watch <key>                        Print the holder every time the lock changes hands
+//	lockz [flags] exec <key> -- <command> [args...]  Hold the lock while running the command
+//
+// The flags map to the fields of lockz.BasicOptions, run "lockz -h" to list them.
+// exec renews the lock with Extend and kills the command once the lock is lost, it exits with the code of the command.
+// (给排程和脚本用的锁)
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	_ "github.com/panhongrainbow/consensusLockz/lockz/etcdz"
+	_ "github.com/panhongrainbow/consensusLockz/lockz/redisz"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	ERROR_USAGE = lockz.Error("lockz command error because the arguments are not correct")
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code, err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "lockz:", err)
+	}
+	os.Exit(code)
+}
+
+// run parses the flags and runs the subcommand, it returns the exit code of the process.
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) (code int, err error) {
+	// Parse the flags in front of the subcommand
+	flags := flag.NewFlagSet("lockz", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	opts := lockz.BasicOptions{Labels: make(map[string]string)}
+	flags.StringVar(&opts.Driver, "driver", "consul", "the driver of the lock service, such as consul, etcd or redis")
+	flags.StringVar(&opts.IpAddressPort, "address", "", "the address of the lock service, the default of the driver if empty")
+	flags.DurationVar(&opts.SessionTTL, "ttl", 10*time.Second, "the lifetime of the session holding the lock")
+	flags.DurationVar(&opts.ExtendPeriod, "extend-period", 0, "the period to renew the lock in exec, half the TTL if zero")
+	flags.DurationVar(&opts.LockDelay, "lock-delay", 0, "the period during which a released lock cannot be acquired again on consul")
+	flags.IntVar(&opts.ExtendLimit, "extend-limit", 1000000, "the maximum number of renewals, which bounds how long exec holds the lock")
+	flags.StringVar(&opts.OwnerID, "owner", "", "the owner ID written into the lock, which re-enters it by locking again")
+	flags.StringVar(&opts.Service, "service", "", "the service name written into the lock")
+	flags.Var(labelsFlag(opts.Labels), "label", "a key=value label written into the lock, repeatable")
+	flags.BoolVar(&opts.Fair, "fair", false, "wait for the lock in the order of arrival")
+	timeout := flags.Duration("timeout", 0, "give up waiting for the lock after the timeout in acquire and exec, wait forever if zero")
+	err = flags.Parse(args)
+	if err != nil {
+		code = 2
+		if errors.Is(err, flag.ErrHelp) {
+			code, err = 0, nil
+		}
+		return
+	}
+	if opts.ExtendPeriod == 0 {
+		opts.ExtendPeriod = opts.SessionTTL / 2
+	}
+
+	// Run the subcommand
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		code, err = 2, ERROR_USAGE
+		return
+	}
+	switch command, args := args[0], args[1:]; {
+	case command == "acquire" && len(args) == 1:
+		err = acquire(ctx, opts, *timeout, args[0], stdout)
+	case command == "release" && len(args) == 2:
+		err = lockz.ReleaseLock(ctx, opts, args[0], args[1])
+	case command == "status" && len(args) == 1:
+		err = status(ctx, opts, args[0], stdout)
+	case command == "list" && len(args) <= 1:
+		err = list(ctx, opts, strings.Join(args, ""), stdout)
+	case command == "break" && len(args) >= 2:
+		err = breakLock(ctx, opts, args[0], strings.Join(args[1:], " "), stdout)
+	case command == "watch" && len(args) == 1:
+		err = watch(ctx, opts, args[0], stdout)
+	case command == "exec" && len(args) >= 3 && args[1] == "--":
+		return execCommand(ctx, opts, *timeout, args[0], args[2:], stdout, stderr)
+	default:
+		flags.Usage()
+		code, err = 2, ERROR_USAGE
+		return
+	}
+	if err != nil {
+		code = 1
+	}
+	return
+}
+
+// labelsFlag collects the repeated -label key=value flags.
+type labelsFlag map[string]string
+
+// String prints the labels.
+func (labels labelsFlag) String() string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set adds a key=value label.
+func (labels labelsFlag) Set(pair string) (err error) {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		err = ERROR_USAGE
+		return
+	}
+	labels[key] = value
+	return
+}
+
+// printJSON prints the value as one line of JSON.
+func printJSON(stdout io.Writer, value interface{}) (err error) {
+	return json.NewEncoder(stdout).Encode(value)
+}
+
+// lock waits for the lock at most the timeout, or forever if the timeout is zero.
+func lock(ctx context.Context, locker *lockz.Locker, timeout time.Duration, key string) (handle *lockz.LockHandle, err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	handle, err = locker.LockContext(ctx, key)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = lockz.ERROR_LOCK_TIMEOUT
+	}
+	return
+}
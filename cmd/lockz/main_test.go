@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test_Check_Commands confirms that the subcommands take, show and release the lock.
+func Test_Check_Commands(t *testing.T) {
+	ctx := context.Background()
+	flags := []string{"-driver", "memory", "-address", "commands_test", "-service", "cron", "-label", "job=backup"}
+
+	// Acquire prints the session
+	stdout := new(bytes.Buffer)
+	code, err := run(ctx, append(flags, "acquire", "commands_test"), stdout, io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, 0, code)
+	sessionID := strings.TrimSpace(stdout.String())
+	require.NotEmpty(t, sessionID)
+
+	// Status and list show the holder with the options
+	stdout.Reset()
+	_, err = run(ctx, append(flags, "status", "commands_test"), stdout, io.Discard)
+	require.NoError(t, err)
+	var info lockz.LockInfo
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &info))
+	require.Equal(t, sessionID, info.Detail.SessionID)
+	require.Equal(t, "cron", info.Detail.OwnerInfo.Service)
+	require.Equal(t, "backup", info.Detail.OwnerInfo.Labels["job"])
+	stdout.Reset()
+	_, err = run(ctx, append(flags, "list"), stdout, io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(stdout.String(), "\n"))
+
+	// Another command cannot take it in time
+	code, err = run(ctx, append(flags, "-timeout", "200ms", "exec", "commands_test", "--", "true"), io.Discard, io.Discard)
+	require.Equal(t, lockz.ERROR_LOCK_TIMEOUT, err)
+	require.Equal(t, 1, code)
+
+	// Release frees it, only with the session
+	code, err = run(ctx, append(flags, "release", "commands_test", "someone-else"), io.Discard, io.Discard)
+	require.Equal(t, lockz.ERROR_NO_AUTH_DEL, err)
+	require.Equal(t, 1, code)
+	_, err = run(ctx, append(flags, "release", "commands_test", sessionID), io.Discard, io.Discard)
+	require.NoError(t, err)
+
+	// Wrong arguments are a usage error
+	code, err = run(ctx, append(flags, "exec", "commands_test", "true"), io.Discard, io.Discard)
+	require.Equal(t, ERROR_USAGE, err)
+	require.Equal(t, 2, code)
+}
+
+// Test_Check_Exec confirms that exec passes the exit code on, and kills the command once the lock is lost.
+func Test_Check_Exec(t *testing.T) {
+	ctx := context.Background()
+	flags := []string{"-driver", "memory", "-address", "exec_test", "-ttl", "1s"}
+
+	// The exit code of the command is passed on, and the lock is released afterwards
+	stdout := new(bytes.Buffer)
+	code, err := run(ctx, append(flags, "exec", "exec_test", "--", "sh", "-c", "echo locked; exit 3"), stdout, io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, 3, code)
+	require.Equal(t, "locked\n", stdout.String())
+	_, err = run(ctx, append(flags, "status", "exec_test"), io.Discard, io.Discard)
+	require.Equal(t, lockz.ERROR_LOCK_RELEASED, err)
+
+	// The command is killed once the lock is broken
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = run(ctx, append(flags, "break", "exec_test", "test"), io.Discard, io.Discard)
+	}()
+	start := time.Now()
+	code, err = run(ctx, append(flags, "exec", "exec_test", "--", "sleep", "10"), io.Discard, io.Discard)
+	require.Error(t, err)
+	require.Equal(t, 1, code)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,221 @@
+package lockz
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// The admin functions let the operator look into the locks of any locker, release them by session, or break a stuck one.
+// Unlike LockStatus, they do not compare the lock with the session of a locker, they only read what is stored.
+// (给维运人员用的)
+
+// AUDIT_PREFIX is the prefix of the audit records written by BreakLock, one record per break under "<prefix><key>/".
+const AUDIT_PREFIX = "lockz/audit/"
+
+// LockInfo is what the operator sees of a lock.
+type LockInfo struct {
+	Key     string       // The lock key
+	Token   uint64       // The fencing token of the holder
+	Detail  LockDetail   // The LockDetail written by the holder
+	Session *SessionInfo // The session of the holder, nil if it is gone or the backend cannot describe it
+}
+
+// AuditRecord is written by BreakLock before breaking the lock, so that the break can be traced afterwards.
+type AuditRecord struct {
+	Key      string     `json:"key"`
+	Broken   LockDetail `json:"broken"`   // The LockDetail of the broken lock
+	Reason   string     `json:"reason"`   // Why the operator broke the lock
+	Operator *OwnerInfo `json:"operator"` // Who broke the lock
+	BrokenAt time.Time  `json:"broken_at"`
+}
+
+// ListLocks lists the locks under the prefix with their holders, the keys which are not locks are left out.
+// The backend of the driver must implement Lister.
+func ListLocks(ctx context.Context, opts BasicOptions, prefix string) (locks []LockInfo, err error) {
+	client, err := adminClient(opts)
+	if err != nil {
+		return
+	}
+	defer closeBackend(client)
+	lister, ok := client.(Lister)
+	if !ok {
+		err = ERROR_CANNOT_LIST
+		return
+	}
+
+	var pairs []*KVPair
+	pairs, err = lister.List(ctx, prefix)
+	if err != nil {
+		return
+	}
+	for _, pair := range pairs {
+		lock, isLock := decodeLock(pair)
+		if !isLock {
+			continue
+		}
+		lock.Session, err = inspectSession(ctx, client, lock.Detail.SessionID)
+		if err != nil {
+			return
+		}
+		locks = append(locks, lock)
+	}
+	return
+}
+
+// InspectLock reads the lock and its holder, it returns ERROR_LOCK_RELEASED if nobody holds the lock.
+func InspectLock(ctx context.Context, opts BasicOptions, key string) (lock LockInfo, err error) {
+	client, err := adminClient(opts)
+	if err != nil {
+		return
+	}
+	defer closeBackend(client)
+
+	var pair *KVPair
+	pair, err = client.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	lock, isLock := decodeLock(pair)
+	if !isLock {
+		err = ERROR_LOCK_RELEASED
+		return
+	}
+	lock.Session, err = inspectSession(ctx, client, lock.Detail.SessionID)
+	return
+}
+
+// BreakLock breaks a stuck lock by destroying the session of its holder, after writing an audit record with the reason.
+// The holder finds out on its next renewal, and every other key of the same session, such as the ones of LockAll, goes with it.
+// On Consul the lock delay of the session still applies before others can take the lock.
+// (强制解锁，留下记录)
+func BreakLock(ctx context.Context, opts BasicOptions, key string, reason string) (record AuditRecord, err error) {
+	client, err := adminClient(opts)
+	if err != nil {
+		return
+	}
+	defer closeBackend(client)
+
+	// Read the holder
+	var pair *KVPair
+	pair, err = client.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	lock, isLock := decodeLock(pair)
+	if !isLock {
+		err = ERROR_LOCK_RELEASED
+		return
+	}
+
+	// Write the audit record first, a break is never left untraced
+	record = AuditRecord{
+		Key:      key,
+		Broken:   lock.Detail,
+		Reason:   reason,
+		BrokenAt: time.Now(),
+	}
+	record.Operator = newOwnerInfo(opts, record.BrokenAt)
+	b, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	err = client.Put(ctx, &KVPair{
+		Key:   AUDIT_PREFIX + key + "/" + strconv.FormatInt(record.BrokenAt.UnixNano(), 10),
+		Value: b,
+	})
+	if err != nil {
+		return
+	}
+
+	// Destroy the session of the holder
+	err = client.DestroySession(ctx, lock.Detail.SessionID)
+	if err != nil {
+		return
+	}
+	opts.logger().Info("lockz lock broken", "key", key, "session_id", lock.Detail.SessionID, "reason", reason)
+
+	// Delete the key as well if the backend kept it, still only if the broken holder has it
+	pair, err = client.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	if held, isLock := decodeLock(pair); isLock && held.Detail.SessionID == lock.Detail.SessionID {
+		if deleter, ok := client.(CASDeleter); ok {
+			_, err = deleter.DeleteCAS(ctx, pair)
+		} else {
+			err = client.Delete(ctx, key)
+		}
+	}
+	return
+}
+
+// ReleaseLock releases the lock held by the session, such as one acquired by another process which has exited.
+// It returns ERROR_NO_AUTH_DEL if another session holds the lock, and ERROR_LOCK_RELEASED if nobody does.
+func ReleaseLock(ctx context.Context, opts BasicOptions, key string, sessionID string) (err error) {
+	client, err := adminClient(opts)
+	if err != nil {
+		return
+	}
+	defer closeBackend(client)
+
+	// Only the holder may release the lock
+	var pair *KVPair
+	pair, err = client.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	lock, isLock := decodeLock(pair)
+	if !isLock {
+		err = ERROR_LOCK_RELEASED
+		return
+	}
+	if lock.Detail.SessionID != sessionID {
+		err = ERROR_NO_AUTH_DEL
+		return
+	}
+
+	// Delete the key first, a key deleted with the session would be kept in the lock delay
+	if deleter, ok := client.(CASDeleter); ok {
+		_, err = deleter.DeleteCAS(ctx, pair)
+	} else {
+		err = client.Delete(ctx, key)
+	}
+	if err != nil {
+		return
+	}
+	return client.DestroySession(ctx, sessionID)
+}
+
+// adminClient creates a client of the driver for the admin functions, the caller closes it once done.
+func adminClient(opts BasicOptions) (client Backend, err error) {
+	factory, err := lookupBackend(opts.Driver)
+	if err != nil {
+		return
+	}
+	return factory(opts)
+}
+
+// decodeLock decodes the pair, and tells whether it is a lock held by a session.
+func decodeLock(pair *KVPair) (lock LockInfo, isLock bool) {
+	if pair == nil {
+		return
+	}
+	if json.Unmarshal(pair.Value, &lock.Detail) != nil || lock.Detail.SessionID == "" {
+		return
+	}
+	lock.Key = pair.Key
+	lock.Token = pair.CreateIndex
+	isLock = true
+	return
+}
+
+// inspectSession describes the session if the backend can, otherwise it returns nil.
+func inspectSession(ctx context.Context, client Backend, sessionID string) (info *SessionInfo, err error) {
+	inspector, ok := client.(SessionInspector)
+	if !ok {
+		return
+	}
+	return inspector.SessionInfo(ctx, sessionID)
+}
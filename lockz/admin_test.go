@@ -0,0 +1,75 @@
+package lockz
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_Admin confirms that the operator sees the locks of any locker with their sessions,
+// and that breaking a lock leaves an audit record and lets others take it.
+func Test_Check_Admin(t *testing.T) {
+	opts := BasicOptions{
+		Driver:        "memory",
+		IpAddressPort: "admin_test",
+		SessionTTL:    10 * time.Second,
+		ExtendLimit:   10,
+		Service:       "billing",
+	}
+	locker, err := NewLocker(opts)
+	require.NoError(t, err)
+	handle0, err := locker.Lock("admin_test/0")
+	require.NoError(t, err)
+	_, err = locker.Lock("admin_test/1")
+	require.NoError(t, err)
+
+	// The operator lists the locks with their holders
+	ctx := context.Background()
+	locks, err := ListLocks(ctx, opts, "admin_test/")
+	require.NoError(t, err)
+	require.Len(t, locks, 2)
+	require.Equal(t, "admin_test/0", locks[0].Key)
+	require.Equal(t, handle0.Token, locks[0].Token)
+	require.Equal(t, handle0.SessionID, locks[0].Detail.SessionID)
+	require.Equal(t, "billing", locks[0].Detail.OwnerInfo.Service)
+	require.NotNil(t, locks[0].Session)
+	require.Equal(t, 10*time.Second, locks[0].Session.TTL)
+	require.Greater(t, locks[0].Session.ExpiresIn, time.Duration(0))
+
+	// The operator breaks the first lock
+	record, err := BreakLock(ctx, opts, "admin_test/0", "stuck in a deploy")
+	require.NoError(t, err)
+	require.Equal(t, handle0.SessionID, record.Broken.SessionID)
+	_, err = InspectLock(ctx, opts, "admin_test/0")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+	_, err = BreakLock(ctx, opts, "admin_test/0", "again")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+
+	// The audit record is kept, and it is not a lock
+	client, err := adminClient(opts)
+	require.NoError(t, err)
+	defer closeBackend(client)
+	audits, err := client.(Lister).List(ctx, AUDIT_PREFIX+"admin_test/0/")
+	require.NoError(t, err)
+	require.Len(t, audits, 1)
+	var stored AuditRecord
+	require.NoError(t, json.Unmarshal(audits[0].Value, &stored))
+	require.Equal(t, "stuck in a deploy", stored.Reason)
+	locks, err = ListLocks(ctx, opts, "")
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+
+	// The holder finds out on its renewal, and others can take the lock
+	require.Error(t, handle0.Incr())
+	other, err := NewLocker(opts)
+	require.NoError(t, err)
+	handle, err := other.TryLockOnce("admin_test/0")
+	require.NoError(t, err)
+
+	// Only the holder releases the lock by its session
+	require.Equal(t, ERROR_NO_AUTH_DEL, ReleaseLock(ctx, opts, "admin_test/0", handle0.SessionID))
+	require.NoError(t, ReleaseLock(ctx, opts, "admin_test/0", handle.SessionID))
+	require.Equal(t, ERROR_LOCK_RELEASED, ReleaseLock(ctx, opts, "admin_test/0", handle.SessionID))
+}
@@ -0,0 +1,174 @@
+package lockz
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The following design is similar to the drivers of [database/sql].
+// Each driver registers a BackendFactory under its name, and BasicOptions.Driver picks one of them.
+
+// Backend is the storage that the distributed lock runs against, such as Consul.
+type Backend interface {
+	// CreateSession creates a session and returns its ID.
+	CreateSession(ctx context.Context, entry SessionEntry) (sessionID string, err error)
+	// RenewSession renews the TTL of a session, it returns ERROR_SESSION_EXPIRED when the session no longer exists.
+	RenewSession(ctx context.Context, sessionID string) (err error)
+	// DestroySession destroys a session, the keys held by the session are released according to its behavior.
+	DestroySession(ctx context.Context, sessionID string) (err error)
+	// Acquire writes the key-value pair only if the key is not held by another session.
+	Acquire(ctx context.Context, pair *KVPair) (acquired bool, err error)
+	// Get returns the key-value pair, or nil if the key does not exist.
+	Get(ctx context.Context, key string) (pair *KVPair, err error)
+	// Put writes the key-value pair without changing which session holds the key.
+	Put(ctx context.Context, pair *KVPair) (err error)
+	// Delete deletes the key.
+	Delete(ctx context.Context, key string) (err error)
+	// Watch blocks until the key changes after waitIndex or the context is done, then returns the key-value pair and the latest index.
+	Watch(ctx context.Context, key string, waitIndex uint64) (pair *KVPair, lastIndex uint64, err error)
+}
+
+// A Backend holding connections also implements io.Closer,
+// the locker closes the clients it no longer uses, and Locker.Close closes the rest.
+
+// closeBackend closes the client if it implements io.Closer.
+func closeBackend(client Backend) (err error) {
+	if closer, ok := client.(io.Closer); ok {
+		err = closer.Close()
+	}
+	return
+}
+
+// IndexOrder is an optional interface of Backend, telling what the CreateIndex of the pairs guarantees.
+// A Backend which does not implement it, such as Consul and etcd, keeps one index for the whole store and guarantees both.
+type IndexOrder interface {
+	// FencingIndex tells whether the CreateIndex of a key grows with every creation of the key, which the fencing tokens rely on.
+	FencingIndex() bool
+	// ArrivalIndex tells whether the CreateIndex grows across all the keys in the order they are created, which the fair queue relies on.
+	ArrivalIndex() bool
+}
+
+// fencingIndex tells whether the client issues fencing tokens.
+func fencingIndex(client Backend) bool {
+	order, ok := client.(IndexOrder)
+	return !ok || order.FencingIndex()
+}
+
+// arrivalIndex tells whether the client keeps the order of arrival in the indexes.
+func arrivalIndex(client Backend) bool {
+	order, ok := client.(IndexOrder)
+	return !ok || order.ArrivalIndex()
+}
+
+// CASDeleter is an optional interface of Backend.
+// DeleteCAS deletes the key only if it has not been modified since the pair was read,
+// which closes the gap between checking the holder and deleting the key in UnLock.
+type CASDeleter interface {
+	DeleteCAS(ctx context.Context, pair *KVPair) (deleted bool, err error)
+}
+
+// CASPutter is an optional interface of Backend.
+// PutCAS writes the pair only if the key has not been modified since it was read,
+// a zero ModifyIndex writes it only if the key does not exist yet, which is needed by Semaphore.
+// Like Put, it does not change which session holds the key, the re-entered locks rely on it.
+type CASPutter interface {
+	PutCAS(ctx context.Context, pair *KVPair) (written bool, err error)
+}
+
+// TxnAcquirer is an optional interface of Backend.
+// AcquireAll acquires all the pairs in one transaction, either all or none of them, which is needed by LockAll.
+type TxnAcquirer interface {
+	AcquireAll(ctx context.Context, pairs []*KVPair) (acquired bool, err error)
+}
+
+// Lister is an optional interface of Backend.
+// List returns the key-value pairs whose keys start with the prefix, sorted by key,
+// which is needed by the locks made of many keys, such as RWLocker.
+type Lister interface {
+	List(ctx context.Context, prefix string) (pairs []*KVPair, err error)
+}
+
+// SessionInspector is an optional interface of Backend.
+// SessionInfo describes the session, or returns nil if it no longer exists, which is needed by InspectLock.
+type SessionInspector interface {
+	SessionInfo(ctx context.Context, sessionID string) (info *SessionInfo, err error)
+}
+
+// SessionInfo describes an alive session in the backend.
+type SessionInfo struct {
+	ID        string        // The ID of the session.
+	Name      string        // The name of the session.
+	Node      string        // The node which the session belongs to, empty if the backend has no nodes.
+	Behavior  string        // What happens to the held keys when the session is invalidated.
+	TTL       time.Duration // The TTL of the session.
+	ExpiresIn time.Duration // The time left before the session expires, zero if the backend cannot tell.
+	LockDelay time.Duration // The period during which released keys cannot be acquired again.
+}
+
+// KVPair is the key-value pair stored in the backend.
+type KVPair struct {
+	Key         string // The key of the pair.
+	Value       []byte // The value of the pair, the lock keys store a LockDetail in JSON.
+	Session     string // The session holding the key, empty if no session holds it.
+	CreateIndex uint64 // The index when the key was created.
+	ModifyIndex uint64 // The index when the key was modified last time.
+	LockIndex   uint64 // The number of times the key has been acquired.
+}
+
+// SessionEntry is the configuration of a new session.
+type SessionEntry struct {
+	Name      string        // The name of the session.
+	Behavior  string        // What happens to the held keys when the session is invalidated, such as "delete".
+	TTL       time.Duration // The session is invalidated if it is not renewed within TTL.
+	LockDelay time.Duration // The period during which released keys cannot be acquired again.
+}
+
+// BackendFactory creates a Backend from the basic options.
+type BackendFactory func(opts BasicOptions) (backend Backend, err error)
+
+var (
+	backendsMutex sync.RWMutex
+	backends      = make(map[string]BackendFactory)
+)
+
+// Register makes a backend available under the driver name.
+// It is meant to be called from init(), and panics if the factory is nil or the driver name is registered twice.
+func Register(driver string, factory BackendFactory) {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+
+	if factory == nil {
+		panic("lockz: Register backend factory is nil")
+	}
+	if _, dup := backends[driver]; dup {
+		panic("lockz: Register called twice for driver " + driver)
+	}
+	backends[driver] = factory
+}
+
+// Drivers returns the sorted names of the registered drivers.
+func Drivers() (drivers []string) {
+	backendsMutex.RLock()
+	defer backendsMutex.RUnlock()
+
+	for driver := range backends {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+	return
+}
+
+// lookupBackend finds the factory registered under the driver name.
+func lookupBackend(driver string) (factory BackendFactory, err error) {
+	backendsMutex.RLock()
+	defer backendsMutex.RUnlock()
+
+	factory, ok := backends[driver]
+	if !ok {
+		err = ERROR_CLIENT_NO_DRIVER
+	}
+	return
+}
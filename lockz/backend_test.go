@@ -0,0 +1,105 @@
+package lockz
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubBackend is a backend that does nothing, it is only used to check the registry.
+type stubBackend struct {
+	Backend
+	opts BasicOptions
+}
+
+// Test_Check_Register confirms that a registered driver is picked by BasicOptions.Driver.
+func Test_Check_Register(t *testing.T) {
+	// Register a stub driver
+	Register("stub", func(opts BasicOptions) (backend Backend, err error) {
+		backend = &stubBackend{opts: opts}
+		return
+	})
+	require.Contains(t, Drivers(), "stub")
+	require.Contains(t, Drivers(), "consul")
+
+	// Registering the same driver twice panics
+	require.Panics(t, func() {
+		Register("stub", func(opts BasicOptions) (backend Backend, err error) {
+			return
+		})
+	})
+	// Registering a nil factory panics
+	require.Panics(t, func() {
+		Register("stub_nil", nil)
+	})
+
+	// The locker uses the stub driver with its options
+	locker, err := NewLocker(BasicOptions{
+		Driver:        "stub",
+		IpAddressPort: "127.0.0.1:8501",
+	})
+	require.NoError(t, err)
+	require.IsType(t, &stubBackend{}, locker.client)
+	require.Equal(t, "127.0.0.1:8501", locker.client.(*stubBackend).opts.IpAddressPort)
+
+	// An unknown driver is refused
+	_, err = NewLocker(BasicOptions{
+		Driver: "unknown",
+	})
+	require.Equal(t, ERROR_CLIENT_NO_DRIVER, err)
+}
+
+// closingBackend is the memory backend counting how many times it is closed.
+type closingBackend struct {
+	Backend
+	closed atomic.Int32
+}
+
+func (b *closingBackend) Close() error {
+	b.closed.Add(1)
+	return nil
+}
+
+// Test_Check_CloseBackend confirms that the client replaced by AlterClient is closed after the locks using it,
+// and Close closes the current one.
+func Test_Check_CloseBackend(t *testing.T) {
+	// Register a driver recording its clients
+	var clients []*closingBackend
+	Register("closing", func(opts BasicOptions) (backend Backend, err error) {
+		memory, err := NewMemoryBackend(opts)
+		if err != nil {
+			return
+		}
+		client := &closingBackend{Backend: memory}
+		clients = append(clients, client)
+		backend = client
+		return
+	})
+	locker, err := NewLocker(BasicOptions{
+		Driver:        "closing",
+		IpAddressPort: "127.0.0.1:8504",
+		SessionTTL:    10 * time.Second,
+		ExtendLimit:   10,
+	})
+	require.NoError(t, err)
+
+	// The lock keeps the first client after it is replaced
+	handle, err := locker.Lock("close_backend_test0")
+	require.NoError(t, err)
+	require.NoError(t, locker.AlterClient("127.0.0.1:8504"))
+	_, err = locker.Lock("close_backend_test1")
+	require.NoError(t, err)
+	require.Len(t, clients, 2)
+	require.Equal(t, int32(0), clients[0].closed.Load())
+
+	// The first client is closed with the last lock using it
+	require.NoError(t, handle.Release())
+	require.Equal(t, int32(1), clients[0].closed.Load())
+
+	// Close closes the current client
+	require.NoError(t, locker.Cancel())
+	require.NoError(t, locker.Close())
+	require.Equal(t, int32(1), clients[0].closed.Load())
+	require.Equal(t, int32(1), clients[1].closed.Load())
+}
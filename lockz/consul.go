@@ -0,0 +1,198 @@
+package lockz
+
+import (
+	"context"
+	"github.com/hashicorp/consul/api"
+	"time"
+)
+
+func init() {
+	Register("consul", NewConsulBackend)
+}
+
+// consulBackend is the Backend on top of the Consul HTTP API.
+type consulBackend struct {
+	client *api.Client
+}
+
+// NewConsulBackend creates a Consul client, using the default config unless IpAddressPort is set.
+func NewConsulBackend(opts BasicOptions) (backend Backend, err error) {
+	// Use default config
+	config := api.DefaultConfig()
+	if opts.IpAddressPort != "" {
+		config.Address = opts.IpAddressPort
+	}
+
+	// Create a client based on config
+	client, err := api.NewClient(config)
+	if err != nil {
+		return
+	}
+
+	backend = &consulBackend{client: client}
+	return
+}
+
+// CreateSession creates a Consul session.
+func (b *consulBackend) CreateSession(ctx context.Context, entry SessionEntry) (sessionID string, err error) {
+	sessionOpts := &api.SessionEntry{
+		Name:      entry.Name,
+		Behavior:  entry.Behavior,
+		TTL:       entry.TTL.String(),
+		LockDelay: entry.LockDelay,
+	}
+	sessionID, _, err = b.client.Session().Create(sessionOpts, (&api.WriteOptions{}).WithContext(ctx))
+	return
+}
+
+// RenewSession renews a Consul session.
+func (b *consulBackend) RenewSession(ctx context.Context, sessionID string) (err error) {
+	var entry *api.SessionEntry
+	entry, _, err = b.client.Session().Renew(sessionID, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return
+	}
+
+	// Consul answers nothing when the session has already been invalidated
+	if entry == nil {
+		err = ERROR_SESSION_EXPIRED
+	}
+	return
+}
+
+// DestroySession destroys a Consul session.
+func (b *consulBackend) DestroySession(ctx context.Context, sessionID string) (err error) {
+	_, err = b.client.Session().Destroy(sessionID, (&api.WriteOptions{}).WithContext(ctx))
+	return
+}
+
+// SessionInfo reads the Consul session, Consul does not tell the time left before it expires.
+func (b *consulBackend) SessionInfo(ctx context.Context, sessionID string) (info *SessionInfo, err error) {
+	var entry *api.SessionEntry
+	entry, _, err = b.client.Session().Info(sessionID, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil || entry == nil {
+		return
+	}
+	info = &SessionInfo{
+		ID:        entry.ID,
+		Name:      entry.Name,
+		Node:      entry.Node,
+		Behavior:  entry.Behavior,
+		LockDelay: entry.LockDelay,
+	}
+	if entry.TTL != "" {
+		info.TTL, err = time.ParseDuration(entry.TTL)
+	}
+	return
+}
+
+// Acquire acquires the key with the session of the pair.
+func (b *consulBackend) Acquire(ctx context.Context, pair *KVPair) (acquired bool, err error) {
+	acquired, _, err = b.client.KV().Acquire(toConsulPair(pair), (&api.WriteOptions{}).WithContext(ctx))
+	return
+}
+
+// AcquireAll acquires all the keys in one Consul transaction of KVLock operations.
+func (b *consulBackend) AcquireAll(ctx context.Context, pairs []*KVPair) (acquired bool, err error) {
+	ops := make(api.TxnOps, 0, len(pairs))
+	for _, pair := range pairs {
+		ops = append(ops, &api.TxnOp{
+			KV: &api.KVTxnOp{
+				Verb:    api.KVLock,
+				Key:     pair.Key,
+				Value:   pair.Value,
+				Session: pair.Session,
+			},
+		})
+	}
+	acquired, _, _, err = b.client.Txn().Txn(ops, (&api.QueryOptions{}).WithContext(ctx))
+	return
+}
+
+// Get reads the key.
+func (b *consulBackend) Get(ctx context.Context, key string) (pair *KVPair, err error) {
+	var keyPair *api.KVPair
+	keyPair, _, err = b.client.KV().Get(key, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return
+	}
+	pair = fromConsulPair(keyPair)
+	return
+}
+
+// Put writes the key.
+func (b *consulBackend) Put(ctx context.Context, pair *KVPair) (err error) {
+	_, err = b.client.KV().Put(toConsulPair(pair), (&api.WriteOptions{}).WithContext(ctx))
+	return
+}
+
+// Delete deletes the key.
+func (b *consulBackend) Delete(ctx context.Context, key string) (err error) {
+	_, err = b.client.KV().Delete(key, (&api.WriteOptions{}).WithContext(ctx))
+	return
+}
+
+// DeleteCAS deletes the key only if its modify index is still the one of the pair.
+func (b *consulBackend) DeleteCAS(ctx context.Context, pair *KVPair) (deleted bool, err error) {
+	deleted, _, err = b.client.KV().DeleteCAS(toConsulPair(pair), (&api.WriteOptions{}).WithContext(ctx))
+	return
+}
+
+// PutCAS writes the key only if its modify index is still the one of the pair, zero means it must not exist.
+func (b *consulBackend) PutCAS(ctx context.Context, pair *KVPair) (written bool, err error) {
+	written, _, err = b.client.KV().CAS(toConsulPair(pair), (&api.WriteOptions{}).WithContext(ctx))
+	return
+}
+
+// List reads the keys under the prefix, Consul sorts them by key.
+func (b *consulBackend) List(ctx context.Context, prefix string) (pairs []*KVPair, err error) {
+	var keyPairs api.KVPairs
+	keyPairs, _, err = b.client.KV().List(prefix, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return
+	}
+	for _, keyPair := range keyPairs {
+		pairs = append(pairs, fromConsulPair(keyPair))
+	}
+	return
+}
+
+// Watch runs a blocking query on the key.
+func (b *consulBackend) Watch(ctx context.Context, key string, waitIndex uint64) (pair *KVPair, lastIndex uint64, err error) {
+	var keyPair *api.KVPair
+	var queryMeta *api.QueryMeta
+	keyPair, queryMeta, err = b.client.KV().Get(key, (&api.QueryOptions{WaitIndex: waitIndex}).WithContext(ctx))
+	if err != nil {
+		return
+	}
+	pair = fromConsulPair(keyPair)
+	lastIndex = queryMeta.LastIndex
+	return
+}
+
+// toConsulPair converts the key-value pair to the one of Consul.
+func toConsulPair(pair *KVPair) *api.KVPair {
+	return &api.KVPair{
+		Key:         pair.Key,
+		Value:       pair.Value,
+		Session:     pair.Session,
+		CreateIndex: pair.CreateIndex,
+		ModifyIndex: pair.ModifyIndex,
+		LockIndex:   pair.LockIndex,
+	}
+}
+
+// fromConsulPair converts the key-value pair of Consul, nil stays nil.
+func fromConsulPair(keyPair *api.KVPair) *KVPair {
+	if keyPair == nil {
+		return nil
+	}
+	return &KVPair{
+		Key:         keyPair.Key,
+		Value:       keyPair.Value,
+		Session:     keyPair.Session,
+		CreateIndex: keyPair.CreateIndex,
+		ModifyIndex: keyPair.ModifyIndex,
+		LockIndex:   keyPair.LockIndex,
+	}
+}
@@ -0,0 +1,70 @@
+package lockz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Do acquires the lock of the key, runs the function holding it and releases it when the function returns or panics,
+// so that the callers no longer lock, renew and release by hand.
+// The lock is renewed meanwhile, unless AutoExtend already does it or no ExtendPeriod is set,
+// and the context of the function is cancelled once the lock is lost or ctx is done, context.Cause tells which.
+// A panic is recovered and returned as ERROR_PANICKED, the error of the function is joined with the loss and the release.
+// Like Lock, the owner named by WithOwner or BasicOptions.OwnerID re-enters the lock it holds, and then only takes its own hold back.
+// (拿锁、干活、放锁，一次搞定)
+func (locker *Locker) Do(ctx context.Context, key string, fn func(ctx context.Context) error) (err error) {
+	// Re-enter the lock held by the owner, or acquire it
+	_, opts := locker.snapshot()
+	handle, err := locker.reenter(ctx, key, ownerOf(ctx, opts))
+	reentered := err == nil
+	if err == ERROR_NOT_HELD || err == ERROR_ALREADY_HELD {
+		handle, err = locker.LockContext(ctx, key)
+	}
+	if err != nil {
+		return
+	}
+
+	// Renew the lock until it is released, the re-entered lock is renewed by its first holder
+	if !reentered && !handle.opts.AutoExtend && handle.opts.ExtendPeriod > 0 {
+		go func() {
+			_ = handle.Extend()
+		}()
+	}
+
+	// The function stops working once the lock is lost
+	work, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(handle.Context(), func() {
+		cancel(context.Cause(handle.Context()))
+	})
+	defer stop()
+
+	// Release the lock whatever happens to the function
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%w: %v", ERROR_PANICKED, recovered)
+		}
+		err = errors.Join(err, locker.finish(ctx, handle))
+	}()
+
+	err = fn(work)
+	return
+}
+
+// finish takes the hold of Do back, it returns the error which made the lock lost, or the error of the release.
+func (locker *Locker) finish(ctx context.Context, handle *LockHandle) (err error) {
+	cause := context.Cause(handle.Context())
+	switch {
+	case cause == nil:
+		// Still held, take the hold back
+		err = handle.leave(ctx)
+	case cause == ERROR_LOCK_RELEASED:
+		// Released by the function itself
+	default:
+		// Lost, the work was not protected, destroy the session left behind anyway
+		err = cause
+		_ = handle.releaseContext(ctx)
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package lockz
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_Do confirms that Do holds the lock while the function runs, and always releases it.
+func Test_Check_Do(t *testing.T) {
+	locker, err := NewLocker(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 20 * time.Millisecond,
+		ExtendLimit:  1000,
+	})
+	require.NoError(t, err)
+
+	// The lock is held and renewed inside, the nested Do of the same owner re-enters it, and the error of the function is returned
+	failure := errors.New("failure")
+	err = locker.Do(WithOwner(context.Background(), "do_test"), "do_test", func(ctx context.Context) error {
+		err := locker.Do(ctx, "do_test", func(ctx context.Context) error {
+			return nil
+		})
+		require.NoError(t, err)
+		_, err = locker.LockStatus("do_test")
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return locker.StatusOf("do_test") == STATUS_EXTENDING
+		}, time.Second, 10*time.Millisecond)
+		return failure
+	})
+	require.ErrorIs(t, err, failure)
+	_, err = locker.LockStatus("do_test")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+
+	// The lock is released even if the function panics
+	err = locker.Do(context.Background(), "do_test", func(ctx context.Context) error {
+		panic("boom")
+	})
+	require.ErrorIs(t, err, ERROR_PANICKED)
+	_, err = locker.LockStatus("do_test")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+}
+
+// Test_Check_DoLost confirms that the function is cancelled once the lock is lost, and the loss is returned.
+func Test_Check_DoLost(t *testing.T) {
+	locker, err := NewLocker(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 20 * time.Millisecond,
+		ExtendLimit:  2,
+	})
+	require.NoError(t, err)
+
+	// The renewal reaches the limit, which cancels the work
+	err = locker.Do(context.Background(), "do_lost_test", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			require.Equal(t, ERROR_CANNOT_EXTEND, context.Cause(ctx))
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, err, ERROR_CANNOT_EXTEND)
+
+	// The session left behind is destroyed with the lock
+	_, err = locker.LockStatus("do_lost_test")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+}
@@ -0,0 +1,124 @@
+package lockz
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Election is the leader election built on Locker, the leader is the candidate holding the election key.
+// The candidate ID is written in LockDetail, so that everyone can find out who the leader is without campaigning.
+// (谁拿到锁，谁就是领导)
+type Election struct {
+	locker Locker // Holds the election key
+	key    string // The election key
+}
+
+// NewElection creates an election entity on the key.
+// ExtendLimit is ignored, the leader is renewed for as long as it lives instead of stepping down after a number of renewals.
+func NewElection(opts BasicOptions, key string) (election Election, err error) {
+	// The limit would take the leadership away on the first renewal by default
+	opts.ExtendLimit = math.MaxInt
+	election.locker, err = NewLocker(opts)
+	election.key = key
+	return
+}
+
+// Campaign blocks until the candidate is elected, or returns ctx.Err() once the context is done.
+// The leadership lasts as long as the returned handle is renewed, use AutoExtend or Extend and watch Lost.
+func (election *Election) Campaign(ctx context.Context, candidateID string) (handle *LockHandle, err error) {
+	return election.locker.lockContext(ctx, election.key, LockDetail{Mode: MODE_EXCLUSIVE, Candidate: candidateID})
+}
+
+// Resign gives up the leadership held by this election entity.
+func (election *Election) Resign() (err error) {
+	handle := election.locker.held.get(election.key)
+	if handle == nil {
+		err = ERROR_NOT_HELD
+		return
+	}
+	return handle.Release()
+}
+
+// Close closes the client of the election entity, resign first.
+func (election *Election) Close() (err error) {
+	return election.locker.Close()
+}
+
+// Leader reads the LockDetail of the current leader, it returns ERROR_NO_LEADER if nobody is elected.
+func (election *Election) Leader() (leader LockDetail, err error) {
+	client, _ := election.locker.snapshot()
+	var keyPair *KVPair
+	keyPair, err = client.Get(context.Background(), election.key)
+	if err != nil {
+		return
+	}
+	leader, err = decodeLeader(keyPair)
+	return
+}
+
+// Observe streams the LockDetail of the leader every time the leadership changes hands, starting with the current one,
+// a zero LockDetail is sent when nobody is elected. The channel is closed once the context is done.
+// (盯着领导换人)
+func (election *Election) Observe(ctx context.Context) <-chan LockDetail {
+	leaders := make(chan LockDetail)
+	go election.observe(ctx, leaders)
+	return leaders
+}
+
+// observe watches the election key with blocking queries, and sends the leader when the session holding it changes.
+func (election *Election) observe(ctx context.Context, leaders chan<- LockDetail) {
+	defer close(leaders)
+
+	client, _ := election.locker.snapshot()
+	var waitIndex uint64
+	var sent bool
+	var last LockDetail
+	for {
+		// Wait for the next change of the election key
+		keyPair, lastIndex, err := client.Watch(ctx, election.key, waitIndex)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// Start over a little later
+			waitIndex = 0
+			select {
+			case <-time.After(DEFAULT_RETRY_INTERVAL):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		waitIndex = lastIndex
+
+		// Only a new session is a new leader, the renewal is not
+		leader, err := decodeLeader(keyPair)
+		if err != nil && err != ERROR_NO_LEADER {
+			continue
+		}
+		if sent && leader.SessionID == last.SessionID {
+			continue
+		}
+
+		// Send the new leader
+		select {
+		case leaders <- leader:
+			sent = true
+			last = leader
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// decodeLeader decodes the LockDetail of the election key, it returns ERROR_NO_LEADER if the key does not exist.
+func decodeLeader(keyPair *KVPair) (leader LockDetail, err error) {
+	if keyPair == nil {
+		err = ERROR_NO_LEADER
+		return
+	}
+	err = json.Unmarshal(keyPair.Value, &leader)
+	return
+}
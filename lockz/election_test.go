@@ -0,0 +1,92 @@
+package lockz
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_Election confirms that only one candidate is elected at a time,
+// and an observer sees every change of the leader without campaigning.
+func Test_Check_Election(t *testing.T) {
+	// Create two candidates and an observer on the same key
+	opts := BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+	}
+	election0, err := NewElection(opts, "election_test")
+	require.NoError(t, err)
+	election1, err := NewElection(opts, "election_test")
+	require.NoError(t, err)
+	observer, err := NewElection(opts, "election_test")
+	require.NoError(t, err)
+
+	// Nobody is elected at first
+	ctx, cancel := context.WithCancel(context.Background())
+	leaders := observer.Observe(ctx)
+	require.Equal(t, "", (<-leaders).Candidate)
+	_, err = observer.Leader()
+	require.Equal(t, ERROR_NO_LEADER, err)
+
+	// The first candidate is elected
+	handle0, err := election0.Campaign(context.Background(), "node-0")
+	require.NoError(t, err)
+	require.Equal(t, "node-0", (<-leaders).Candidate)
+	leader, err := observer.Leader()
+	require.NoError(t, err)
+	require.Equal(t, "node-0", leader.Candidate)
+	require.Equal(t, handle0.SessionID, leader.SessionID)
+
+	// The other candidate waits in vain, and the renewal is not a change of the leader
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancelTimeout()
+	_, err = election1.Campaign(timeout, "node-1")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, handle0.Incr())
+	leader, err = observer.Leader()
+	require.NoError(t, err)
+	require.Equal(t, "node-0", leader.Candidate)
+	require.Equal(t, 1, leader.Extend)
+	select {
+	case leader = <-leaders:
+		t.Fatal("the renewal is observed as a new leader", leader)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// The leader resigns, and the other candidate is elected
+	require.NoError(t, election0.Resign())
+	require.Equal(t, ERROR_NOT_HELD, election0.Resign())
+	require.Equal(t, "", (<-leaders).Candidate)
+	_, err = election1.Campaign(context.Background(), "node-1")
+	require.NoError(t, err)
+	require.Equal(t, "node-1", (<-leaders).Candidate)
+
+	// The stream ends with the context
+	cancel()
+	for range leaders {
+	}
+	require.NoError(t, election1.Resign())
+}
+
+// Test_Check_ElectionRenewal confirms that the leader is not bound by ExtendLimit, which is 0 by default.
+func Test_Check_ElectionRenewal(t *testing.T) {
+	election, err := NewElection(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		AutoExtend:   true,
+		ExtendPeriod: 20 * time.Millisecond,
+	}, "election_renewal_test")
+	require.NoError(t, err)
+
+	// The leader keeps its leadership over several renewals
+	handle, err := election.Campaign(context.Background(), "node-0")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		leader, err := election.Leader()
+		return err == nil && leader.Extend >= 3
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, handle.Context().Err())
+	require.NoError(t, election.Resign())
+}
@@ -1,14 +1,18 @@
 package lockz
 
 import (
-	"github.com/hashicorp/consul/api"
+	"os"
+	"sync"
 	"time"
 )
 
 const (
-	DEFAULT_SESSION_TIMEOUT = "10s" // Seconds
+	DEFAULT_SESSION_TIMEOUT = "10s"                  // Seconds
+	DEFAULT_RETRY_INTERVAL  = 100 * time.Millisecond // Wait before competing again after losing the lock
 )
 
+// Deprecated: the states before the state machine keep their values, but the locker no longer reports them.
+// Use the Status constants with Locker.Status, StatusOf and Transitions instead.
 const (
 	STATUS_LOCK_CHECKED_OPTIONS uint32 = iota + 1
 	STATUS_LOCK_INITED
@@ -30,89 +34,153 @@ const (
 	ERROR_OCCUPY_BY_OTHER  = Error("Distributed lock error because the lock was already acquired by another client")
 	ERROR_LOCK_RELEASED    = Error("Distributed lock error because the lock was released")
 	ERROR_CLIENT_NO_DRIVER = Error("Distributed lock error because the client has no driver configured")
+	ERROR_SESSION_EXPIRED  = Error("Distributed lock error because the session has expired")
 
 	// It is impossible to have this error, the lock will time out if over time, this does not need to be considered.
 	// ERROR_LOCK_NO_CHANGE  = Error("distributed lock error because no changes in the TTL duration")
 )
 
 const (
-	ERROR_NO_AUTH_DEL = Error("Distributed lock error because there is no permission to delete the key")
+	ERROR_NO_AUTH_DEL     = Error("Distributed lock error because there is no permission to delete the key")
+	ERROR_ALREADY_HELD    = Error("Distributed lock error because the lock is already held by this locker")
+	ERROR_NOT_HELD        = Error("Distributed lock error because the lock is not held by this locker")
+	ERROR_LOCK_TIMEOUT    = Error("Distributed lock error because the lock was not acquired in time")
+	ERROR_CANNOT_LIST     = Error("Distributed lock error because the backend cannot list keys")
+	ERROR_CANNOT_CAS      = Error("Distributed lock error because the backend cannot check-and-set keys")
+	ERROR_CANNOT_TXN      = Error("Distributed lock error because the backend cannot acquire keys in a transaction")
+	ERROR_NO_KEYS         = Error("Distributed lock error because no key is given")
+	ERROR_SEMAPHORE_LIMIT = Error("Distributed lock error because the semaphore limit differs from the one of the other holders")
+	ERROR_SEMAPHORE_SLOTS = Error("Distributed lock error because the semaphore needs at least one slot")
+	ERROR_NO_LEADER       = Error("Distributed lock error because there is no leader")
+	ERROR_STALE_TOKEN     = Error("Distributed lock error because the fencing token belongs to an earlier holder")
+	ERROR_PANICKED        = Error("Distributed lock error because the function holding the lock panicked")
+	ERROR_NO_FENCING      = Error("Distributed lock error because the backend cannot issue fencing tokens")
+	ERROR_CANNOT_QUEUE    = Error("Distributed lock error because the backend cannot line the contenders up in the order of arrival")
 )
 
-// Locker is the distributed lock entity.
+// Locker is the distributed lock entity, it is safe for concurrent use by multiple goroutines.
+// The client, the options and the session TTL are guarded by the mutex,
+// and the locks held and their status are kept in collections of their own.
+// (多个协程共用一个 Locker 也安全)
 type Locker struct {
-	client      *api.Client             // Client for the lock service (single Goroutine Lock protect)
-	reEstablish bool                    // Re-establish the Consul client
-	sessionID   string                  // ID of the session
-	sessionTTL  string                  // Time-to-live for the session
-	release     chan doneAndReleaseLock // Channel for releasing the lock (single Goroutine Lock protect)
-	status      uint32                  // The Locker's status
-	Opts        LockerOptions           // BasicOptions for the lock
+	mutex       *sync.RWMutex  // Guards the client, reEstablish, sessionTTL and Opts, shared by the copies of the locker
+	client      Backend        // Backend for the lock service
+	reEstablish bool           // Re-establish the Consul client
+	sessionTTL  string         // Time-to-live for the session
+	held        *heldLocks     // The locks held by this locker, each with a session of its own
+	status      *statusMachine // The status of the keys locked by this locker
+	Opts        LockerOptions  // BasicOptions for the lock
 }
 
-// doneAndReleaseLock is the signal to send when the work is done to release the lock.
+// doneAndReleaseLock is the signal closed when the work is done to release the lock.
 type doneAndReleaseLock struct{}
 
+// The modes of the lock written in LockDetail.
+const (
+	MODE_EXCLUSIVE = ""          // The lock of Locker, left out of the JSON
+	MODE_READ      = "read"      // A reader of RWLocker, sharing the lock with the other readers
+	MODE_WRITE     = "write"     // The writer of RWLocker
+	MODE_SEMAPHORE = "semaphore" // A holder of Semaphore
+	MODE_QUEUE     = "queue"     // A contender waiting in the fair queue
+)
+
+// LOCK_DETAIL_VERSION is the schema version of LockDetail written by this package.
+// The records written before the version was introduced read as version 0.
+// A new version may only add fields, so that the older readers still understand the record.
+const LOCK_DETAIL_VERSION = 1
+
 // LockDetail needs to be written into the lock key of Consul.
 type LockDetail struct {
-	SessionID  string    `json:"session_id"`
-	Extend     int       `json:"extend"`
-	UpdateTime time.Time `json:"update_time"`
+	Version    int        `json:"version,omitempty"`
+	SessionID  string     `json:"session_id"`
+	Mode       string     `json:"mode,omitempty"`
+	Candidate  string     `json:"candidate,omitempty"`
+	Owner      string     `json:"owner,omitempty"`
+	OwnerInfo  *OwnerInfo `json:"owner_info,omitempty"`
+	HoldCount  int        `json:"hold_count,omitempty"`
+	Extend     int        `json:"extend"`
+	UpdateTime time.Time  `json:"update_time"`
+}
+
+// OwnerInfo tells the operator reading the lock key which host, process and service hold the lock.
+// (谁拿着锁)
+type OwnerInfo struct {
+	Hostname   string            `json:"hostname,omitempty"`
+	PID        int               `json:"pid,omitempty"`
+	Service    string            `json:"service,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	AcquiredAt time.Time         `json:"acquired_at"`
+}
+
+// newOwnerInfo describes this process holding a lock acquired at the time, with the service and labels of the options.
+func newOwnerInfo(opts BasicOptions, acquiredAt time.Time) (info *OwnerInfo) {
+	info = &OwnerInfo{
+		PID:        os.Getpid(),
+		Service:    opts.Service,
+		Labels:     opts.Labels,
+		AcquiredAt: acquiredAt,
+	}
+	info.Hostname, _ = os.Hostname()
+	return
 }
 
 // NewLocker creates a locker entity.
 func NewLocker(opts BasicOptions) (locker Locker, err error) {
+	// Set the mutex and the options first, the followings depend on them
+	locker.mutex = new(sync.RWMutex)
+	locker.Opts.Basic = opts
+
+	// The durations must be valid, and the automatic renewal cannot run without a period
+	err = checkTimingOpts(opts)
+	if err != nil {
+		return
+	}
+
 	// Reload Session TTL
 	err = locker.ReloadSessionTTL()
 	if err != nil {
 		return
 	}
 
-	// Create a consul client
-	locker.Opts.Basic = opts
+	// Create a client of the driver
 	err = locker.CreateClient()
 
-	// SessionID is only available when the lock is acquired.
-	// I want to ensure that when the lock is not acquired, the SessionID immediately becomes empty.
-	// (没抢到锁，立刻为空)
-
-	// Set the channel for releasing the lock
-	locker.release = make(chan doneAndReleaseLock)
+	// SessionID is only available when the lock is acquired, each LockHandle keeps its own.
+	// I want to ensure that when the lock is not acquired, the session is destroyed immediately.
+	// (没抢到锁，立刻销毁)
 
-	// Change the status to initialization.
-	locker.status = STATUS_LOCK_INITED
+	// Set the collection of the held locks, and their status starting from idle
+	locker.held = newHeldLocks()
+	locker.status = newStatusMachine()
 
 	return
 }
 
 // CreateClient initializes a locker client.
 func (locker *Locker) CreateClient() (err error) {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	return locker.createClient()
+}
+
+// createClient initializes a locker client. The caller must hold the mutex.
+func (locker *Locker) createClient() (err error) {
 	// If a client is nil, proceed to create one.
 	// The main reason is to maintain client stability and avoid arbitrarily reconstructing.
 	// (为了稳定，不随意重建)
 	if locker.client == nil {
-		//
-		switch locker.Opts.Basic.Driver {
-		case "consul":
-			// Use default config
-			config := api.DefaultConfig()
-			if locker.Opts.Basic.IpAddressPort != "" {
-				config.Address = locker.Opts.Basic.IpAddressPort
-			}
-			// Create a client based on config
-			locker.client, err = api.NewClient(config)
-			if err != nil {
-				return
-			}
-		case "mock":
-			/*consulMock := mockconsul.NewConsul(t)
-			cfg.Address = consulMock.URL()*/
-			SetupMock()
-		default:
-			err = ERROR_CLIENT_NO_DRIVER
+		// Find the backend registered under the driver name
+		var factory BackendFactory
+		factory, err = lookupBackend(locker.Opts.Basic.Driver)
+		if err != nil {
+			return
+		}
+		// Create a client based on the basic options
+		locker.client, err = factory(locker.Opts.Basic)
+		if err != nil {
 			return
 		}
-
 	}
 
 	// Return no error if a client created or already exists
@@ -127,6 +195,9 @@ func (locker *Locker) AlterClient(IpAddressPort string) (err error) {
 		return
 	}
 
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
 	// Update IpAddressPort option
 	locker.Opts.Basic.IpAddressPort = IpAddressPort
 
@@ -136,3 +207,55 @@ func (locker *Locker) AlterClient(IpAddressPort string) (err error) {
 	// Return no error
 	return
 }
+
+// reEstablishClient switches to a new client if AlterClient has marked it.
+// The locks already held keep the client which created their sessions, so their renewal is not disturbed,
+// and the old client is closed after them.
+// (已持有的锁继续用原来的客户端)
+func (locker *Locker) reEstablishClient() (err error) {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	if locker.reEstablish == true {
+		oldClient := locker.client
+		locker.client = nil
+		err = locker.createClient()
+		if err != nil {
+			// Keep the old client, try again next time
+			locker.client = oldClient
+			locker.Opts.Basic.logger().Error("lockz client not re-established", "address", locker.Opts.Basic.IpAddressPort, "error", err)
+			return
+		}
+		locker.reEstablish = false
+		locker.Opts.Basic.logger().Info("lockz client re-established", "address", locker.Opts.Basic.IpAddressPort)
+
+		// Close the old client once the locks using it are released
+		if oldClient != nil {
+			locker.held.retire(oldClient)
+		}
+	}
+	return
+}
+
+// Close closes the client of the locker, and the old clients kept for the locks acquired before AlterClient.
+// Release the locks first, the locks still held can neither be renewed nor released afterwards.
+func (locker *Locker) Close() (err error) {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	err = locker.held.closeRetired()
+	closeErr := closeBackend(locker.client)
+	if err == nil {
+		err = closeErr
+	}
+	return
+}
+
+// snapshot returns the current client and options, so that one operation uses them consistently
+// even if the client is switched meanwhile.
+func (locker *Locker) snapshot() (client Backend, opts BasicOptions) {
+	locker.mutex.RLock()
+	defer locker.mutex.RUnlock()
+
+	return locker.client, locker.Opts.Basic
+}
@@ -2,7 +2,12 @@ package lockz
 
 import (
 	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // Test_Check_Locker is to confirm when the client function will change,
@@ -12,7 +17,7 @@ func Test_Check_Locker(t *testing.T) {
 	var locker Locker
 	var err error
 	// Create new locker with empty options
-	locker, err = NewLocker(BasicOptions{})
+	locker, err = NewLocker(BasicOptions{Driver: "memory"})
 	require.NoError(t, err)
 
 	// Save old client
@@ -41,3 +46,140 @@ func Test_Check_Locker(t *testing.T) {
 	//
 	return
 }
+
+// Test_Check_ConcurrentLocker shares one locker among goroutines locking their own keys and competing for a common one,
+// while the client is switched and the locks are renewed meanwhile. Run it with -race.
+func Test_Check_ConcurrentLocker(t *testing.T) {
+	// Create new locker shared by all goroutines
+	locker, err := NewLocker(BasicOptions{
+		Driver:        "memory",
+		IpAddressPort: "127.0.0.1:18501",
+		SessionTTL:    10 * time.Second,
+		ExtendPeriod:  10 * time.Millisecond,
+		ExtendLimit:   1000,
+	})
+	require.NoError(t, err)
+
+	// The goroutines send their errors back, the test goroutine checks them
+	errs := make(chan error, 17)
+
+	// Keep switching the client to the same store
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				errs <- nil
+				return
+			default:
+				if err := locker.AlterClient("127.0.0.1:18501"); err != nil {
+					errs <- err
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	// Count the goroutines inside the common critical section, and how often they met there
+	var inside, overlaps int32
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+
+		// Lock an own key, renew it for a while and release it
+		go func(i int) {
+			defer wg.Done()
+			errs <- func() error {
+				key := "concurrent_locker_test" + strconv.Itoa(i)
+				for j := 0; j < 5; j++ {
+					handle, err := locker.Lock(key)
+					if err != nil {
+						return err
+					}
+					done := make(chan error)
+					go func() {
+						done <- handle.Extend()
+					}()
+					time.Sleep(30 * time.Millisecond)
+					if _, err = locker.LockStatus(key); err != nil {
+						return err
+					}
+					if _, err = locker.UnLock(key); err != nil {
+						return err
+					}
+					if err = <-done; err != nil {
+						return err
+					}
+				}
+				return nil
+			}()
+		}(i)
+
+		// Compete for the common key with the other goroutines sharing the locker
+		go func() {
+			defer wg.Done()
+			errs <- func() error {
+				for j := 0; j < 5; j++ {
+					handle, err := locker.Lock("concurrent_locker_test")
+					if err != nil {
+						return err
+					}
+					if atomic.AddInt32(&inside, 1) != 1 {
+						atomic.AddInt32(&overlaps, 1)
+					}
+					time.Sleep(time.Millisecond)
+					atomic.AddInt32(&inside, -1)
+					if err = handle.Release(); err != nil {
+						return err
+					}
+				}
+				return nil
+			}()
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	for i := 0; i < 17; i++ {
+		require.NoError(t, <-errs)
+	}
+	require.Zero(t, atomic.LoadInt32(&overlaps))
+
+	// Nothing is left held
+	require.Empty(t, locker.held.all())
+}
+
+// Test_Check_OwnerInfo confirms that the lock tells who holds it, and the renewal keeps it.
+func Test_Check_OwnerInfo(t *testing.T) {
+	locker, err := NewLocker(BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+		Service:     "billing",
+		Labels:      map[string]string{"request": "req-1"},
+	})
+	require.NoError(t, err)
+
+	// The owner block is written on locking
+	handle, err := locker.Lock("owner_info_test")
+	require.NoError(t, err)
+	detail, err := handle.Status()
+	require.NoError(t, err)
+	hostname, _ := os.Hostname()
+	require.Equal(t, LOCK_DETAIL_VERSION, detail.Version)
+	require.NotNil(t, detail.OwnerInfo)
+	require.Equal(t, hostname, detail.OwnerInfo.Hostname)
+	require.Equal(t, os.Getpid(), detail.OwnerInfo.PID)
+	require.Equal(t, "billing", detail.OwnerInfo.Service)
+	require.Equal(t, "req-1", detail.OwnerInfo.Labels["request"])
+	require.True(t, detail.OwnerInfo.AcquiredAt.Equal(handle.AcquiredAt))
+
+	// The renewal keeps it
+	require.NoError(t, handle.Incr())
+	renewed, err := handle.Status()
+	require.NoError(t, err)
+	require.Equal(t, 1, renewed.Extend)
+	require.Equal(t, detail.OwnerInfo, renewed.OwnerInfo)
+	require.NoError(t, handle.Release())
+}
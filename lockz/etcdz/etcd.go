@@ -0,0 +1,334 @@
+// Package etcdz registers the "etcd" driver of lockz.
+// A session of lockz is mapped onto an etcd lease, the keys held by the session are attached to the lease,
+// so they are deleted when the lease is revoked or expires.
+//
+//	import _ "github.com/panhongrainbow/consensusLockz/lockz/etcdz"
+package etcdz
+
+import (
+	"context"
+	"errors"
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_ENDPOINT     = "127.0.0.1:2379"
+	DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+)
+
+func init() {
+	lockz.Register("etcd", NewEtcdBackend)
+}
+
+// etcdBackend is the lockz.Backend on top of the etcd v3 client.
+type etcdBackend struct {
+	client *clientv3.Client
+}
+
+// NewEtcdBackend creates an etcd client, connecting to DEFAULT_ENDPOINT unless IpAddressPort is set.
+func NewEtcdBackend(opts lockz.BasicOptions) (backend lockz.Backend, err error) {
+	// Use the default endpoint
+	endpoint := DEFAULT_ENDPOINT
+	if opts.IpAddressPort != "" {
+		endpoint = opts.IpAddressPort
+	}
+
+	// Create a client based on the endpoint
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: DEFAULT_DIAL_TIMEOUT,
+	})
+	if err != nil {
+		return
+	}
+
+	backend = &etcdBackend{client: client}
+	return
+}
+
+// Close closes the etcd client, which is called by the locker no longer using it.
+func (b *etcdBackend) Close() error {
+	return b.client.Close()
+}
+
+// CreateSession grants a lease with the session TTL, rounded up to whole seconds.
+// etcd has no lock delay, and the keys are always deleted with the lease, whatever the behavior is.
+func (b *etcdBackend) CreateSession(ctx context.Context, entry lockz.SessionEntry) (sessionID string, err error) {
+	ttl := int64((entry.TTL + time.Second - 1) / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	var lease *clientv3.LeaseGrantResponse
+	lease, err = b.client.Grant(ctx, ttl)
+	if err != nil {
+		return
+	}
+
+	sessionID = formatLeaseID(lease.ID)
+	return
+}
+
+// RenewSession keeps the lease alive once.
+func (b *etcdBackend) RenewSession(ctx context.Context, sessionID string) (err error) {
+	leaseID, err := parseLeaseID(sessionID)
+	if err != nil {
+		return
+	}
+
+	_, err = b.client.KeepAliveOnce(ctx, leaseID)
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		err = lockz.ERROR_SESSION_EXPIRED
+	}
+	return
+}
+
+// DestroySession revokes the lease, which deletes the keys attached to it.
+func (b *etcdBackend) DestroySession(ctx context.Context, sessionID string) (err error) {
+	leaseID, err := parseLeaseID(sessionID)
+	if err != nil {
+		return
+	}
+
+	_, err = b.client.Revoke(ctx, leaseID)
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		// Already expired, nothing left to destroy
+		err = nil
+	}
+	return
+}
+
+// SessionInfo reads the lease, which has no name, node or lock delay, and always deletes its keys.
+func (b *etcdBackend) SessionInfo(ctx context.Context, sessionID string) (info *lockz.SessionInfo, err error) {
+	leaseID, err := parseLeaseID(sessionID)
+	if err != nil {
+		return
+	}
+
+	var resp *clientv3.LeaseTimeToLiveResponse
+	resp, err = b.client.TimeToLive(ctx, leaseID)
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		err = nil
+		return
+	}
+	if err != nil || resp.TTL < 0 {
+		return
+	}
+	info = &lockz.SessionInfo{
+		ID:        sessionID,
+		Behavior:  "delete",
+		TTL:       time.Duration(resp.GrantedTTL) * time.Second,
+		ExpiresIn: time.Duration(resp.TTL) * time.Second,
+	}
+	return
+}
+
+// Acquire creates the key attached to the lease if it does not exist,
+// or updates the value if the key is already attached to the same lease.
+func (b *etcdBackend) Acquire(ctx context.Context, pair *lockz.KVPair) (acquired bool, err error) {
+	leaseID, err := parseLeaseID(pair.Session)
+	if err != nil {
+		return
+	}
+	put := clientv3.OpPut(pair.Key, string(pair.Value), clientv3.WithLease(leaseID))
+
+	// Create the key only if nobody holds it (CreateRevision == 0),
+	// otherwise update it only if this lease already holds it
+	var resp *clientv3.TxnResponse
+	resp, err = b.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(pair.Key), "=", 0)).
+		Then(put).
+		Else(clientv3.OpTxn(
+			[]clientv3.Cmp{clientv3.Compare(clientv3.LeaseValue(pair.Key), "=", leaseID)},
+			[]clientv3.Op{put},
+			nil,
+		)).
+		Commit()
+	if err != nil {
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			err = lockz.ERROR_SESSION_EXPIRED
+		}
+		return
+	}
+
+	acquired = resp.Succeeded || resp.Responses[0].GetResponseTxn().Succeeded
+	return
+}
+
+// AcquireAll creates all the keys attached to their leases in one transaction, only if none of them exists.
+func (b *etcdBackend) AcquireAll(ctx context.Context, pairs []*lockz.KVPair) (acquired bool, err error) {
+	compares := make([]clientv3.Cmp, 0, len(pairs))
+	puts := make([]clientv3.Op, 0, len(pairs))
+	for _, pair := range pairs {
+		var leaseID clientv3.LeaseID
+		leaseID, err = parseLeaseID(pair.Session)
+		if err != nil {
+			return
+		}
+		compares = append(compares, clientv3.Compare(clientv3.CreateRevision(pair.Key), "=", 0))
+		puts = append(puts, clientv3.OpPut(pair.Key, string(pair.Value), clientv3.WithLease(leaseID)))
+	}
+
+	var resp *clientv3.TxnResponse
+	resp, err = b.client.Txn(ctx).If(compares...).Then(puts...).Commit()
+	if err != nil {
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			err = lockz.ERROR_SESSION_EXPIRED
+		}
+		return
+	}
+	acquired = resp.Succeeded
+	return
+}
+
+// Get reads the key.
+func (b *etcdBackend) Get(ctx context.Context, key string) (pair *lockz.KVPair, err error) {
+	pair, _, err = b.get(ctx, key)
+	return
+}
+
+// Put writes the key and keeps the lease it is attached to.
+func (b *etcdBackend) Put(ctx context.Context, pair *lockz.KVPair) (err error) {
+	// WithIgnoreLease fails on a missing key, so a missing key is put plainly
+	_, err = b.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Version(pair.Key), ">", 0)).
+		Then(clientv3.OpPut(pair.Key, string(pair.Value), clientv3.WithIgnoreLease())).
+		Else(clientv3.OpPut(pair.Key, string(pair.Value))).
+		Commit()
+	return
+}
+
+// Delete deletes the key.
+func (b *etcdBackend) Delete(ctx context.Context, key string) (err error) {
+	_, err = b.client.Delete(ctx, key)
+	return
+}
+
+// DeleteCAS deletes the key only if its modify revision is still the one of the pair.
+func (b *etcdBackend) DeleteCAS(ctx context.Context, pair *lockz.KVPair) (deleted bool, err error) {
+	var resp *clientv3.TxnResponse
+	resp, err = b.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(pair.Key), "=", int64(pair.ModifyIndex))).
+		Then(clientv3.OpDelete(pair.Key)).
+		Commit()
+	if err != nil {
+		return
+	}
+	deleted = resp.Succeeded
+	return
+}
+
+// PutCAS writes the key only if its mod revision is still the modify index of the pair,
+// a zero modify index writes it only if the key does not exist.
+func (b *etcdBackend) PutCAS(ctx context.Context, pair *lockz.KVPair) (written bool, err error) {
+	// An existing key keeps its lease, like Put
+	compare := clientv3.Compare(clientv3.ModRevision(pair.Key), "=", int64(pair.ModifyIndex))
+	put := clientv3.OpPut(pair.Key, string(pair.Value), clientv3.WithIgnoreLease())
+	if pair.ModifyIndex == 0 {
+		compare = clientv3.Compare(clientv3.CreateRevision(pair.Key), "=", 0)
+		put = clientv3.OpPut(pair.Key, string(pair.Value))
+	}
+
+	var resp *clientv3.TxnResponse
+	resp, err = b.client.Txn(ctx).
+		If(compare).
+		Then(put).
+		Commit()
+	if err != nil {
+		return
+	}
+	written = resp.Succeeded
+	return
+}
+
+// List reads the keys under the prefix, sorted by key.
+func (b *etcdBackend) List(ctx context.Context, prefix string) (pairs []*lockz.KVPair, err error) {
+	var resp *clientv3.GetResponse
+	resp, err = b.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return
+	}
+	for _, kv := range resp.Kvs {
+		pairs = append(pairs, fromEtcdKeyValue(kv))
+	}
+	return
+}
+
+// Watch waits for the events of the key after the revision waitIndex, then reads the key.
+func (b *etcdBackend) Watch(ctx context.Context, key string, waitIndex uint64) (pair *lockz.KVPair, lastIndex uint64, err error) {
+	if waitIndex > 0 {
+		// Stop the watch channel when returning
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		for resp := range b.client.Watch(watchCtx, key, clientv3.WithRev(int64(waitIndex)+1)) {
+			// A compacted revision means the key has changed for sure
+			if resp.CompactRevision != 0 || len(resp.Events) > 0 {
+				break
+			}
+			err = resp.Err()
+			if err != nil {
+				return
+			}
+		}
+
+		// The watch channel is also closed when the context is done
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+	}
+
+	return b.get(ctx, key)
+}
+
+// get reads the key, and returns the revision of the store as the index.
+func (b *etcdBackend) get(ctx context.Context, key string) (pair *lockz.KVPair, revision uint64, err error) {
+	var resp *clientv3.GetResponse
+	resp, err = b.client.Get(ctx, key)
+	if err != nil {
+		return
+	}
+
+	revision = uint64(resp.Header.Revision)
+	if len(resp.Kvs) > 0 {
+		pair = fromEtcdKeyValue(resp.Kvs[0])
+	}
+	return
+}
+
+// fromEtcdKeyValue converts the key-value of etcd, the lease becomes the session.
+// etcd has no lock index, so it is left zero.
+func fromEtcdKeyValue(kv *mvccpb.KeyValue) (pair *lockz.KVPair) {
+	pair = &lockz.KVPair{
+		Key:         string(kv.Key),
+		Value:       kv.Value,
+		CreateIndex: uint64(kv.CreateRevision),
+		ModifyIndex: uint64(kv.ModRevision),
+	}
+	if kv.Lease != 0 {
+		pair.Session = formatLeaseID(clientv3.LeaseID(kv.Lease))
+	}
+	return
+}
+
+// formatLeaseID formats the lease ID as the session ID.
+func formatLeaseID(leaseID clientv3.LeaseID) string {
+	return strconv.FormatInt(int64(leaseID), 16)
+}
+
+// parseLeaseID parses the session ID back to the lease ID.
+func parseLeaseID(sessionID string) (leaseID clientv3.LeaseID, err error) {
+	id, err := strconv.ParseInt(sessionID, 16, 64)
+	if err != nil {
+		err = lockz.ERROR_SESSION_EXPIRED
+		return
+	}
+	leaseID = clientv3.LeaseID(id)
+	return
+}
@@ -0,0 +1,246 @@
+package etcdz
+
+import (
+	"context"
+	"fmt"
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// startEtcd starts an embedded etcd server for the test, and returns its client address.
+func startEtcd(t *testing.T) (ipAddressPort string) {
+	// Pick two free ports for the client and the peer
+	clientURL, peerURL := freeURL(t), freeURL(t)
+
+	// Configure a single node cluster in a temporary directory
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	// Start the server and wait until it is ready
+	server, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("embedded etcd is not ready")
+	}
+
+	return clientURL.Host
+}
+
+// freeURL finds a free local port.
+func freeURL(t *testing.T) url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.TCPAddr).Port)}
+}
+
+// Test_Check_EtcdLock tests two lockers on etcd, lock and unlock them alternatively, and wait on each other.
+func Test_Check_EtcdLock(t *testing.T) {
+	ipAddressPort := startEtcd(t)
+
+	// Create two lockers with the etcd driver
+	var locker0, locker1 lockz.Locker
+	var err error
+	locker0, err = lockz.NewLocker(lockz.BasicOptions{
+		Driver:        "etcd",
+		IpAddressPort: ipAddressPort,
+		SessionTTL:    5 * time.Second,
+	})
+	require.NoError(t, err)
+	locker1, err = lockz.NewLocker(lockz.BasicOptions{
+		Driver:        "etcd",
+		IpAddressPort: ipAddressPort,
+		SessionTTL:    5 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// Lock and unlock them alternatively
+	for i := 0; i < 3; i++ {
+		var handle *lockz.LockHandle
+		handle, err = locker0.Lock("etcd_lock_test")
+		require.NoError(t, err)
+		require.NotNil(t, handle)
+		_, err = locker0.UnLock("etcd_lock_test")
+		require.NoError(t, err)
+
+		handle, err = locker1.Lock("etcd_lock_test")
+		require.NoError(t, err)
+		require.NotNil(t, handle)
+		_, err = locker1.UnLock("etcd_lock_test")
+		require.NoError(t, err)
+	}
+
+	// locker0 holds the lock, locker1 waits on it in vain
+	handle, err := locker0.Lock("etcd_lock_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	_, err = locker1.LockContext(ctx, "etcd_lock_test")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// locker1 is woken up as soon as locker0 unlocks
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		_, _ = locker0.UnLock("etcd_lock_test")
+	}()
+	handle, err = locker1.Lock("etcd_lock_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+}
+
+// Test_Check_EtcdExtend confirms that the lease is renewed by Extend, and the key is deleted with the lease.
+func Test_Check_EtcdExtend(t *testing.T) {
+	ipAddressPort := startEtcd(t)
+
+	// Create a locker whose lease expires quickly
+	locker, err := lockz.NewLocker(lockz.BasicOptions{
+		Driver:        "etcd",
+		IpAddressPort: ipAddressPort,
+		SessionTTL:    2 * time.Second,
+		ExtendPeriod:  1 * time.Second,
+		ExtendLimit:   3,
+	})
+	require.NoError(t, err)
+
+	// Acquire the lock and renew it in the background
+	handle, err := locker.Lock("etcd_extend_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+	done := make(chan error)
+	go func() {
+		done <- locker.Extend("etcd_extend_test")
+	}()
+
+	// The lock outlives its TTL while it is renewed, up to the limit
+	time.Sleep(3500 * time.Millisecond)
+	detail, err := locker.LockStatus("etcd_extend_test")
+	require.Equal(t, lockz.ERROR_CANNOT_EXTEND, err)
+	require.Equal(t, 3, detail.Extend)
+
+	// The renewal stops at the limit, then the lease expires and the key is gone
+	require.Equal(t, lockz.ERROR_CANNOT_EXTEND, <-done)
+	require.Eventually(t, func() bool {
+		_, err = locker.LockStatus("etcd_extend_test")
+		return err == lockz.ERROR_LOCK_RELEASED
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+// Test_Check_EtcdRWLock confirms that the readers share the lock on etcd, and the writer waits for them to drain.
+func Test_Check_EtcdRWLock(t *testing.T) {
+	ipAddressPort := startEtcd(t)
+	opts := lockz.BasicOptions{
+		Driver:        "etcd",
+		IpAddressPort: ipAddressPort,
+		SessionTTL:    5 * time.Second,
+		ExtendLimit:   10,
+	}
+
+	// Create two readers and a writer
+	reader0, err := lockz.NewRWLocker(opts)
+	require.NoError(t, err)
+	reader1, err := lockz.NewRWLocker(opts)
+	require.NoError(t, err)
+	writer, err := lockz.NewRWLocker(opts)
+	require.NoError(t, err)
+
+	// The readers share the lock
+	handle0, err := reader0.RLock("etcd_rw_lock_test")
+	require.NoError(t, err)
+	handle1, err := reader1.RLock("etcd_rw_lock_test")
+	require.NoError(t, err)
+
+	// The writer waits for the readers in vain
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err = writer.LockContext(ctx, "etcd_rw_lock_test")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The writer gets the lock once the readers drain
+	require.NoError(t, reader0.RUnlock(handle0))
+	require.NoError(t, reader1.RUnlock(handle1))
+	handle, err := writer.Lock("etcd_rw_lock_test")
+	require.NoError(t, err)
+	detail, err := handle.Status()
+	require.NoError(t, err)
+	require.Equal(t, lockz.MODE_WRITE, detail.Mode)
+	require.NoError(t, writer.UnLock("etcd_rw_lock_test"))
+}
+
+// Test_Check_EtcdSemaphore confirms that the slots of the semaphore are taken with check-and-set on etcd.
+func Test_Check_EtcdSemaphore(t *testing.T) {
+	ipAddressPort := startEtcd(t)
+
+	// Create the semaphore with one slot
+	semaphore, err := lockz.NewSemaphore(lockz.BasicOptions{
+		Driver:        "etcd",
+		IpAddressPort: ipAddressPort,
+		SessionTTL:    5 * time.Second,
+		ExtendLimit:   10,
+	}, "etcd_semaphore_test", 1)
+	require.NoError(t, err)
+
+	// The first holder gets the slot, and the second one waits in vain
+	handle, err := semaphore.Acquire()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err = semaphore.AcquireContext(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The released slot can be taken again
+	require.NoError(t, semaphore.Release(handle))
+	handle, err = semaphore.Acquire()
+	require.NoError(t, err)
+	require.NoError(t, semaphore.Release(handle))
+}
+
+// Test_Check_EtcdLockAll confirms that the keys are acquired in one transaction or none of them.
+func Test_Check_EtcdLockAll(t *testing.T) {
+	ipAddressPort := startEtcd(t)
+
+	// Create two lockers
+	opts := lockz.BasicOptions{
+		Driver:        "etcd",
+		IpAddressPort: ipAddressPort,
+		SessionTTL:    5 * time.Second,
+		ExtendLimit:   10,
+	}
+	locker0, err := lockz.NewLocker(opts)
+	require.NoError(t, err)
+	locker1, err := lockz.NewLocker(opts)
+	require.NoError(t, err)
+
+	// One of the keys is held by the other locker, so nothing is acquired
+	handle, err := locker1.Lock("etcd_lock_all_test_b")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err = locker0.LockAllContext(ctx, []string{"etcd_lock_all_test_a", "etcd_lock_all_test_b"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = locker1.LockStatus("etcd_lock_all_test_a")
+	require.Equal(t, lockz.ERROR_LOCK_RELEASED, err)
+
+	// All of them are acquired once the key is released, and released together
+	require.NoError(t, handle.Release())
+	handles, err := locker0.LockAll([]string{"etcd_lock_all_test_a", "etcd_lock_all_test_b"})
+	require.NoError(t, err)
+	require.Len(t, handles, 2)
+	require.NoError(t, locker0.UnLockAll([]string{"etcd_lock_all_test_a", "etcd_lock_all_test_b"}))
+	_, err = locker1.LockStatus("etcd_lock_all_test_b")
+	require.Equal(t, lockz.ERROR_LOCK_RELEASED, err)
+}
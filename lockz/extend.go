@@ -1,101 +1,181 @@
 package lockz
 
 import (
+	"context"
 	"encoding/json"
-	"github.com/hashicorp/consul/api"
 	"time"
 )
 
 // Extend continuously extends a distributed lock identified by a key by renewing the session.
 func (locker *Locker) Extend(key string) (err error) {
+	return locker.ExtendContext(context.Background(), key)
+}
+
+// ExtendContext is the same as Extend, but stops renewing, releases the lock and returns ctx.Err() once the context is done.
+func (locker *Locker) ExtendContext(ctx context.Context, key string) (err error) {
+	// Find the handle holding the key
+	handle := locker.held.get(key)
+	if handle == nil {
+		err = ERROR_NOT_HELD
+		return
+	}
+
+	return handle.ExtendContext(ctx)
+}
+
+// Cancel is to release all the distributed locks held by the locker, which also stops their renewal.
+// The locks lost by the locker are released as well, to clean up their sessions.
+func (locker *Locker) Cancel() (err error) {
+	for _, handle := range locker.held.all() {
+		// The key of a lost lock is usually gone already
+		lost := handle.Context().Err() != nil
+		releaseErr := handle.Release()
+		if err == nil && !lost {
+			err = releaseErr
+		}
+	}
+	return
+}
+
+// Incr increments the value of a lock identified by a key.
+func (locker *Locker) Incr(key string) (err error) {
+	// Find the handle holding the key
+	handle := locker.held.get(key)
+	if handle == nil {
+		err = ERROR_NOT_HELD
+		return
+	}
+
+	return handle.Incr()
+}
+
+// Extend continuously extends the lock of the handle by renewing its session, until the handle is released.
+func (handle *LockHandle) Extend() (err error) {
+	return handle.ExtendContext(context.Background())
+}
+
+// ExtendContext is the same as Extend, but stops renewing, releases the lock and returns ctx.Err() once the context is done.
+// The handles locked together by LockAll share the session, any one of them renews all of them.
+// It returns ERROR_EXTENDED_PERIOD_FORMAT at once if no ExtendPeriod is set, the lock is kept.
+func (handle *LockHandle) ExtendContext(ctx context.Context) (err error) {
+	// The renewal cannot run without a period
+	if handle.opts.ExtendPeriod <= 0 {
+		err = ERROR_EXTENDED_PERIOD_FORMAT
+		return
+	}
+
+	// The locks are renewed from now on
+	for _, member := range handle.members() {
+		handle.status.transit(member.opts, member.Key, STATUS_EXTENDING)
+	}
+
 	// Create a ticker for the extended period
-	ticker := time.NewTicker(locker.Opts.Basic.ExtendPeriod)
+	ticker := time.NewTicker(handle.opts.ExtendPeriod)
+	defer ticker.Stop()
 	// Loop continuously
 	for {
-		// Select on either the ticker or release channel
+		// Select on the ticker, the release channel or the context
 		select {
 		case <-ticker.C:
 			// On ticker, renew the session and extend the lock
-			_, _, err = locker.client.Session().Renew(locker.sessionID, nil)
-			if err != nil {
-				return
+			_, span := handle.opts.startSpan(ctx, "lockz.Extend", ATTRIBUTE_KEY.String(handle.Key), ATTRIBUTE_SESSION_ID.String(handle.SessionID))
+			var extend int
+			err = handle.client.RenewSession(ctx, handle.SessionID)
+			for _, member := range handle.members() {
+				if err == nil {
+					// Increment the value
+					extend, err = member.incr()
+				}
+				member.opts.metrics().ObserveRenew(member.Key, err)
+			}
+			span.SetAttributes(ATTRIBUTE_EXTEND.Int(extend))
+			endSpan(span, err)
+			if err == nil {
+				handle.opts.logger().Debug("lockz lock renewed", "key", handle.Key, "session_id", handle.SessionID, "extend", extend)
 			}
-			// Increment the value
-			err = locker.Incr(key)
 			if err != nil {
+				// Released by another goroutine in the middle of the tick, it is not a failure
+				if handle.released() {
+					err = nil
+					return
+				}
+				// Otherwise the lock is lost, tell the protected work
+				handle.lose(err)
 				return
 			}
-		case <-locker.release:
-			// Complete the work and release the distributed lock
-			err = locker.DestroySession()
+		case <-handle.release:
+			// The work is done and the distributed lock has been released
+			return
+		case <-ctx.Done():
+			// Deadline or shutdown, release the distributed lock and report why
+			_ = handle.Release()
+			err = ctx.Err()
 			return
 		}
 	}
 }
 
-// Cancel is to send a signal in order to release the distributed lock.
-func (locker *Locker) Cancel() (err error) {
-	locker.release <- doneAndReleaseLock{}
+// Incr increments the value of the lock held by the handle.
+func (handle *LockHandle) Incr() (err error) {
+	_, span := handle.opts.startSpan(context.Background(), "lockz.Incr", ATTRIBUTE_KEY.String(handle.Key), ATTRIBUTE_SESSION_ID.String(handle.SessionID))
+	var extend int
+	extend, err = handle.incr()
+	handle.opts.metrics().ObserveRenew(handle.Key, err)
+	span.SetAttributes(ATTRIBUTE_EXTEND.Int(extend))
+	endSpan(span, err)
 	return
 }
 
-// Incr increments the value of a lock identified by a key.
-func (locker *Locker) Incr(key string) (err error) {
-	// Get the key-value pair from the client
-	var keyPair *api.KVPair
-	keyPair, _, err = locker.client.KV().Get(key, nil)
-	if err != nil {
-		return
-	}
+// incr increments the value of the lock held by the handle and returns the new count, without measuring it.
+func (handle *LockHandle) incr() (extend int, err error) {
+	handle.detailMutex.Lock()
+	defer handle.detailMutex.Unlock()
 
-	// If the key-value pair is nil, return ERROR_LOCK_RELEASED
-	if keyPair == nil {
-		err = ERROR_LOCK_RELEASED
-		return
-	}
-
-	// Unmarshal the value to a LockDetail struct
-	var keyValue LockDetail
-	err = json.Unmarshal(keyPair.Value, &keyValue)
+	for {
+		// Get the key-value pair from the client
+		var keyPair *KVPair
+		keyPair, err = handle.client.Get(context.Background(), handle.Key)
+		if err != nil {
+			return
+		}
 
-	// If the ExtendLimit is reached, return ERROR_CANNOT_EXTEND
-	if locker.Opts.Basic.ExtendLimit <= keyValue.Extend {
-		err = ERROR_CANNOT_EXTEND
-		return
-	}
+		// If the key-value pair is nil, return ERROR_LOCK_RELEASED
+		if keyPair == nil {
+			err = ERROR_LOCK_RELEASED
+			return
+		}
 
-	// If the session ID does not match, return ERROR_OCCUPY_BY_OTHER
-	if locker.sessionID != keyValue.SessionID {
-		err = ERROR_OCCUPY_BY_OTHER
-		return
-	}
+		// Unmarshal the value to a LockDetail struct
+		var keyValue LockDetail
+		err = json.Unmarshal(keyPair.Value, &keyValue)
 
-	// Increment the Extended field of the LockDetail struct
-	value := LockDetail{
-		SessionID:  locker.sessionID,
-		Extend:     keyValue.Extend + 1,
-		UpdateTime: time.Now(),
-	}
+		// If the ExtendLimit is reached, return ERROR_CANNOT_EXTEND
+		if handle.opts.ExtendLimit <= keyValue.Extend {
+			handle.status.transit(handle.opts, handle.Key, STATUS_EXTEND_LIMIT_REACHED)
+			err = ERROR_CANNOT_EXTEND
+			return
+		}
 
-	// Marshal the struct to JSON bytes
-	b, err := json.Marshal(value)
-	if err != nil {
-		return
-	}
+		// If the session ID does not match, return ERROR_OCCUPY_BY_OTHER
+		if handle.SessionID != keyValue.SessionID {
+			err = ERROR_OCCUPY_BY_OTHER
+			return
+		}
 
-	// Assemble the new key-value pair
-	lockOpts := &api.KVPair{
-		Key:     key,
-		Value:   b,
-		Session: locker.sessionID,
-	}
+		// Increment the Extended field of the LockDetail struct, the other fields are kept
+		value := keyValue
+		value.Extend = keyValue.Extend + 1
 
-	// Update the new key-value pair to the Consul
-	_, err = locker.client.KV().Put(lockOpts, nil)
-	if err != nil {
-		return
+		// Update the new key-value pair, read it again if it changed meanwhile
+		var written bool
+		written, err = putDetail(context.Background(), handle.client, keyPair, value)
+		if err != nil {
+			return
+		}
+		if written {
+			// Return the new count and no error on success
+			extend = value.Extend
+			return
+		}
 	}
-
-	// Return no error on success
-	return
 }
@@ -10,10 +10,11 @@ import (
 
 // Test_Check_Extend confirms whether the distributed lock can be renewed properly.
 func Test_Check_Extend(t *testing.T) {
-	// Create new locker with empty options
+	// Create new locker with the memory driver
 	var locker Locker
 	var err error
 	locker, err = NewLocker(BasicOptions{
+		Driver:       "memory",
 		SessionTTL:   3 * time.Second,
 		ExtendPeriod: 2 * time.Second,
 		ExtendLimit:  3,
@@ -21,9 +22,9 @@ func Test_Check_Extend(t *testing.T) {
 	require.NoError(t, err)
 
 	// Acquire the lock
-	var acquired bool
-	acquired, err = locker.Lock("extend_test")
-	require.True(t, acquired)
+	var handle *LockHandle
+	handle, err = locker.Lock("extend_test")
+	require.NotNil(t, handle)
 	require.NoError(t, err)
 
 	// Add 1 goroutine
@@ -60,25 +61,31 @@ func Test_Check_Extend(t *testing.T) {
 				// Keep paying attention to the latest status of the lock
 				detail, err := locker.LockStatus("extend_test")
 				// Here strictly confirm that the condition of the distributed lock is working properly, reaching ERROR_CANNOT_EXTEND and 3
+				// Confirm that this lock belongs to this session
+				require.Equal(t, handle.SessionID, detail.SessionID)
 				if err == ERROR_CANNOT_EXTEND && detail.Extend == 3 {
 					wg.Done()
+					return
 				}
-				// Confirm that this lock belongs to this session
-				require.Equal(t, locker.sessionID, detail.SessionID)
 			}
 		}
 	}()
 
 	// Wait for the goroutine to finish
 	wg.Wait()
+
+	// Stop the renewal before the next tick fails on the limit
+	err = locker.Cancel()
+	require.NoError(t, err)
 }
 
 // Test_Check_Incr lets this test freely and unlimitedly increments the value of the distributed lock
 func Test_Check_Incr(t *testing.T) {
-	// Create new locker with empty options
+	// Create new locker with the memory driver
 	var locker Locker
 	var err error
 	locker, err = NewLocker(BasicOptions{
+		Driver:       "memory",
 		SessionTTL:   10 * time.Second, // (Just set enough time to accumulate the Incr amount !)
 		ExtendPeriod: 9 * time.Second,  // (Just set enough time to accumulate the Incr amount !)
 		ExtendLimit:  20,               // (Just set enough time to accumulate the Incr amount !)
@@ -86,9 +93,9 @@ func Test_Check_Incr(t *testing.T) {
 	require.NoError(t, err)
 
 	// Acquire the lock
-	var acquired bool
-	acquired, err = locker.Lock("incr_test")
-	require.True(t, acquired)
+	var handle *LockHandle
+	handle, err = locker.Lock("incr_test")
+	require.NotNil(t, handle)
 	require.NoError(t, err)
 
 	// Loop to increment the locker's value
@@ -102,7 +109,7 @@ func Test_Check_Incr(t *testing.T) {
 	require.NoError(t, err)
 
 	// Confirm that this lock belongs to this session
-	require.Equal(t, locker.sessionID, detail.SessionID)
+	require.Equal(t, handle.SessionID, detail.SessionID)
 
 	// Confirm the increment amount
 	require.Equal(t, 10, detail.Extend)
@@ -110,10 +117,11 @@ func Test_Check_Incr(t *testing.T) {
 
 // Test_Check_Cancel is to confirm whether the cancel function can release the distributed lock on time.
 func Test_Check_Cancel(t *testing.T) {
-	// Create new locker with empty options
+	// Create new locker with the memory driver
 	var locker Locker
 	var err error
 	locker, err = NewLocker(BasicOptions{
+		Driver:       "memory",
 		SessionTTL:   10 * time.Second,
 		ExtendPeriod: 9 * time.Second,
 		ExtendLimit:  1,
@@ -121,9 +129,9 @@ func Test_Check_Cancel(t *testing.T) {
 	require.NoError(t, err)
 
 	// Acquire the lock
-	var acquired bool
-	acquired, err = locker.Lock("cancel_test")
-	require.True(t, acquired)
+	var handle *LockHandle
+	handle, err = locker.Lock("cancel_test")
+	require.NotNil(t, handle)
 	require.NoError(t, err)
 
 	// Add 1 goroutine
@@ -131,9 +139,10 @@ func Test_Check_Cancel(t *testing.T) {
 	wg.Add(1)
 
 	// Run the goroutine
-	// This goroutine will automatically renew the distributed lock.
+	// This goroutine will automatically renew the distributed lock through its handle,
+	// which stops at once even if the lock is released before it starts.
 	go func() {
-		err := locker.Extend("cancel_test")
+		err := handle.Extend()
 		require.NoError(t, err)
 		wg.Done()
 	}()
@@ -152,3 +161,132 @@ func Test_Check_Cancel(t *testing.T) {
 	// Wait for the goroutine to finish
 	wg.Wait()
 }
+
+// Test_Check_ExtendContext is to confirm whether cancelling the context stops the renewal and releases the distributed lock.
+func Test_Check_ExtendContext(t *testing.T) {
+	// Create new locker
+	var locker Locker
+	var err error
+	locker, err = NewLocker(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 9 * time.Second,
+		ExtendLimit:  1,
+	})
+	require.NoError(t, err)
+
+	// Acquire the lock
+	var handle *LockHandle
+	handle, err = locker.Lock("extend_context_test")
+	require.NotNil(t, handle)
+	require.NoError(t, err)
+
+	// Run the renewal with a context that is cancelled soon
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- locker.ExtendContext(ctx, "extend_context_test")
+	}()
+	cancel()
+
+	// The renewal returns the context error
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	// Wait 1 second
+	time.Sleep(1 * time.Second)
+
+	// The distributed lock has been released
+	_, err = locker.LockStatus("extend_context_test")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+}
+
+// Test_Check_AutoExtend confirms that Lock starts the renewal by itself, the release stops it,
+// and the error which stops it is received from Lost.
+func Test_Check_AutoExtend(t *testing.T) {
+	// Create new locker renewing the lock automatically
+	locker, err := NewLocker(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 100 * time.Millisecond,
+		ExtendLimit:  3,
+		AutoExtend:   true,
+	})
+	require.NoError(t, err)
+
+	// The lock is renewed until the limit is reached, which is reported to Lost
+	handle, err := locker.Lock("auto_extend_test")
+	require.NoError(t, err)
+	select {
+	case err = <-handle.Lost():
+		require.Equal(t, ERROR_CANNOT_EXTEND, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the renewal never stops")
+	}
+	detail, err := handle.Status()
+	require.Equal(t, ERROR_CANNOT_EXTEND, err)
+	require.Equal(t, 3, detail.Extend)
+	require.NoError(t, handle.Release())
+
+	// The release stops the renewal quietly
+	handle, err = locker.Lock("auto_extend_test")
+	require.NoError(t, err)
+	time.Sleep(150 * time.Millisecond)
+	detail, err = handle.Status()
+	require.NoError(t, err)
+	require.Equal(t, 1, detail.Extend)
+	require.NoError(t, handle.Release())
+	select {
+	case err = <-handle.Lost():
+		t.Fatal("the release is reported as lost", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// The renewal cannot run without a period
+	_, err = NewLocker(BasicOptions{Driver: "memory", AutoExtend: true})
+	require.Equal(t, ERROR_EXTENDED_PERIOD_FORMAT, err)
+	locker, err = NewLocker(BasicOptions{Driver: "memory", SessionTTL: 10 * time.Second, ExtendLimit: 10})
+	require.NoError(t, err)
+	handle, err = locker.Lock("auto_extend_test")
+	require.NoError(t, err)
+	require.Equal(t, ERROR_EXTENDED_PERIOD_FORMAT, handle.Extend())
+	require.NoError(t, handle.Release())
+}
+
+// Test_Check_LockLost confirms that the protected work is told the moment the renewal finds the lock lost.
+func Test_Check_LockLost(t *testing.T) {
+	// Create new locker
+	locker, err := NewLocker(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 50 * time.Millisecond,
+		ExtendLimit:  100,
+	})
+	require.NoError(t, err)
+
+	// Acquire the lock and renew it
+	handle, err := locker.Lock("lock_lost_test")
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- handle.Extend()
+	}()
+
+	// The session is gone behind our back
+	err = locker.client.DestroySession(context.Background(), handle.SessionID)
+	require.NoError(t, err)
+
+	// The renewal fails, the loss is received from Lost and cancels the context
+	require.Equal(t, ERROR_SESSION_EXPIRED, <-done)
+	require.Equal(t, ERROR_SESSION_EXPIRED, <-handle.Lost())
+	<-handle.Context().Done()
+	require.Equal(t, ERROR_SESSION_EXPIRED, context.Cause(handle.Context()))
+
+	// A lock released normally only cancels the context
+	handle, err = locker.Lock("lock_lost_test")
+	require.NoError(t, err)
+	require.NoError(t, handle.Context().Err())
+	require.NoError(t, handle.Release())
+	<-handle.Context().Done()
+	require.Equal(t, ERROR_LOCK_RELEASED, context.Cause(handle.Context()))
+	require.Empty(t, handle.Lost())
+}
@@ -0,0 +1,184 @@
+package lockz
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// The fair mode lines the contenders up in a queue under "<key>/queue/".
+// Each contender registers an entry bound to its session and waits only for the entry right before it,
+// so a release wakes up the next contender instead of all of them, and the lock is taken in the order of arrival.
+// The entries are ordered by their create index, which follows the arrival on consul, etcd and memory.
+// The create index of redis counts per key, so the fair mode refuses it with ERROR_CANNOT_QUEUE.
+// The session of the entry is renewed while it waits, however long the queue is.
+// (排队，不抢)
+
+// fairLockContext acquires the lock written with the detail after the contenders which arrived earlier.
+func (locker *Locker) fairLockContext(ctx context.Context, key string, detail LockDetail) (handle *LockHandle, err error) {
+	// Go back to idle if the lock is not acquired, the lock held by another goroutine keeps its status
+	defer func() {
+		if err != nil && err != ERROR_ALREADY_HELD {
+			locker.transit(key, STATUS_IDLE)
+		}
+	}()
+
+	// Do not start anything if the context is already done
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	// The lock is already held by this locker, do not lose it
+	if locker.held.get(key) != nil {
+		err = ERROR_ALREADY_HELD
+		return
+	}
+
+	// If client connection status changed, recreate a client
+	err = locker.reEstablishClient()
+	if err != nil {
+		return
+	}
+	client, opts := locker.snapshot()
+
+	// The queue is found by listing the entries, and lined up by their indexes
+	lister, ok := client.(Lister)
+	if !ok {
+		err = ERROR_CANNOT_LIST
+		return
+	}
+	if !arrivalIndex(client) {
+		err = ERROR_CANNOT_QUEUE
+		return
+	}
+
+	// Register the entry with a session of its own, the same session holds the lock later
+	var sessionID string
+	sessionID, err = locker.createSession(ctx, client)
+	if err != nil {
+		return
+	}
+	entry := queuePrefix(key) + sessionID
+	err = locker.enqueue(ctx, client, sessionID, entry)
+	if err != nil {
+		_ = destroySession(context.WithoutCancel(ctx), client, opts, sessionID)
+		return
+	}
+
+	// Wait for the turn and try to lock, the session must outlive the wait
+	stop := locker.keepSession(ctx, client, sessionID)
+	handle, err = locker.fairTryLock(ctx, client, lister, opts, sessionID, key, entry, detail)
+	for err == ERROR_OCCUPY_BY_OTHER {
+		// Taken by a contender out of the queue, or the lock delay is not over, try again a little later
+		select {
+		case <-time.After(DEFAULT_RETRY_INTERVAL):
+			handle, err = locker.fairTryLock(ctx, client, lister, opts, sessionID, key, entry, detail)
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	stop()
+
+	// Leave the queue, the session goes with it if the lock is not acquired
+	if err != nil {
+		_ = destroySession(context.WithoutCancel(ctx), client, opts, sessionID)
+		handle = nil
+		return
+	}
+	_ = client.Delete(context.Background(), entry)
+	return
+}
+
+// fairTryLock waits until the entry is the head of the queue and the lock is released, then tries to acquire the lock.
+func (locker *Locker) fairTryLock(ctx context.Context, client Backend, lister Lister, opts BasicOptions, sessionID string, key string, entry string, detail LockDetail) (handle *LockHandle, err error) {
+	for {
+		// Wait only for the entry right before this one
+		var predecessor string
+		predecessor, err = predecessorOf(ctx, lister, key, entry)
+		if err != nil {
+			return
+		}
+		if predecessor == "" {
+			break
+		}
+		locker.status.transit(opts, key, STATUS_WAITING)
+		err = locker.BlockOnReleased(ctx, predecessor)
+		if err != ERROR_LOCK_RELEASED {
+			return
+		}
+	}
+
+	// The head of the queue waits for the lock to be released
+	locker.status.transit(opts, key, STATUS_WAITING)
+	err = locker.BlockOnReleased(ctx, key)
+	if err != ERROR_LOCK_RELEASED {
+		return
+	}
+
+	// Try to lock with the session of the entry, keeping it on failure
+	return locker.acquire(ctx, client, opts, sessionID, key, detail)
+}
+
+// enqueue writes the entry of the contender bound to its session.
+func (locker *Locker) enqueue(ctx context.Context, client Backend, sessionID string, entry string) (err error) {
+	// Write the LockDetail of the entry, telling the operator who is waiting
+	_, opts := locker.snapshot()
+	value := LockDetail{
+		Version:    LOCK_DETAIL_VERSION,
+		SessionID:  sessionID,
+		Mode:       MODE_QUEUE,
+		Owner:      opts.OwnerID,
+		UpdateTime: time.Now(),
+	}
+	value.OwnerInfo = newOwnerInfo(opts, value.UpdateTime)
+	b, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	// The entry is named after the session, nobody else takes it
+	var acquired bool
+	acquired, err = client.Acquire(ctx, &KVPair{Key: entry, Value: b, Session: sessionID})
+	if err == nil && !acquired {
+		err = ERROR_OCCUPY_BY_OTHER
+	}
+	return
+}
+
+// predecessorOf finds the entry right before the entry in the queue, or "" if the entry is the head.
+// It returns ERROR_SESSION_EXPIRED if the entry has left the queue with its session.
+func predecessorOf(ctx context.Context, lister Lister, key string, entry string) (predecessor string, err error) {
+	var pairs []*KVPair
+	pairs, err = lister.List(ctx, queuePrefix(key))
+	if err != nil {
+		return
+	}
+
+	// Line the entries up in the order of arrival
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].CreateIndex != pairs[j].CreateIndex {
+			return pairs[i].CreateIndex < pairs[j].CreateIndex
+		}
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	for i, pair := range pairs {
+		if pair.Key != entry {
+			continue
+		}
+		if i > 0 {
+			predecessor = pairs[i-1].Key
+		}
+		return
+	}
+
+	err = ERROR_SESSION_EXPIRED
+	return
+}
+
+// queuePrefix is the prefix of the entries of the fair queue.
+func queuePrefix(key string) string {
+	return key + "/queue/"
+}
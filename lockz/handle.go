@@ -0,0 +1,414 @@
+package lockz
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// LockHandle is returned by a successful Lock, it holds one key with a session of its own,
+// so a locker can hold many keys and release each of them independently.
+type LockHandle struct {
+	Key        string    // The key of the lock
+	SessionID  string    // The session holding the lock
+	Token      uint64    // The fencing token, it increases with every new acquisition of the key
+	AcquiredAt time.Time // When the lock was acquired
+
+	client      Backend                 // The backend where the session lives, it stays even if the locker switches its client
+	opts        BasicOptions            // The options of the locker when the lock was acquired
+	held        *heldLocks              // The locks held by the locker, the handle removes itself on release
+	status      *statusMachine          // The status of the keys locked by the locker
+	release     chan doneAndReleaseLock // Closed on release, which stops the renewal
+	lost        chan error              // Receives the error which stops the renewal
+	loseOnce    sync.Once               // Report the loss only once
+	ctx         context.Context         // Cancelled once the lock is lost or released
+	cancel      context.CancelCauseFunc // Cancels ctx with the reason
+	releaseOnce *sync.Once              // Release only once, shared by the handles locked together
+	group       []*LockHandle           // The handles locked together by LockAll, released together
+	detailMutex sync.Mutex              // Serializes the changes of the LockDetail
+	owner       string                  // The owner re-entering the lock, empty if nobody does
+}
+
+// newHandle creates the handle of the lock acquired by the session.
+func (locker *Locker) newHandle(client Backend, opts BasicOptions, sessionID string, key string, token uint64, acquiredAt time.Time) (handle *LockHandle) {
+	handle = &LockHandle{
+		Key:         key,
+		SessionID:   sessionID,
+		Token:       token,
+		AcquiredAt:  acquiredAt,
+		client:      client,
+		opts:        opts,
+		held:        locker.held,
+		status:      locker.status,
+		release:     make(chan doneAndReleaseLock),
+		lost:        make(chan error, 1),
+		releaseOnce: new(sync.Once),
+		owner:       opts.OwnerID,
+	}
+	handle.ctx, handle.cancel = context.WithCancelCause(context.Background())
+
+	// A token which is not monotonic would be worse than none
+	if !fencingIndex(client) {
+		handle.Token = 0
+	}
+	return
+}
+
+// hold records the handles as held by the locker, and keeps them alive in the background if asked.
+// The handles locked together are renewed as one by the first of them.
+// (自动续约)
+func (locker *Locker) hold(handles ...*LockHandle) {
+	for _, handle := range handles {
+		locker.held.add(handle)
+		handle.status.transit(handle.opts, handle.Key, STATUS_LOCKED)
+		handle.opts.metrics().LockHeld(handle.Key)
+	}
+	if len(handles) > 0 && handles[0].opts.AutoExtend {
+		go handles[0].autoExtend()
+	}
+}
+
+// The fencing token is the index when the lock key was created.
+// The lock key is created by each acquisition and deleted with the session, and the index never goes back,
+// so a later holder always gets a larger token, while Incr does not change it.
+// Downstream storage can reject the writes carrying a token smaller than the largest one it has seen.
+// The backends whose indexes do not grow per key, such as Redlock, issue no tokens, and the Token of their handles is zero.
+// (后来的持有者，令牌一定更大)
+
+// ValidateToken checks whether the fencing token still belongs to the current holder of the lock.
+// It returns ERROR_LOCK_RELEASED if nobody holds the lock, and ERROR_STALE_TOKEN if another acquisition has taken place.
+// It returns ERROR_NO_FENCING if the backend issues no fencing tokens.
+func (locker *Locker) ValidateToken(key string, token uint64) (err error) {
+	// Get the key-value pair for the lock
+	client, _ := locker.snapshot()
+	if !fencingIndex(client) {
+		err = ERROR_NO_FENCING
+		return
+	}
+	var keyPair *KVPair
+	keyPair, err = client.Get(context.Background(), key)
+	if err != nil {
+		return
+	}
+
+	// The lock has been released
+	if keyPair == nil {
+		err = ERROR_LOCK_RELEASED
+		return
+	}
+
+	// The lock has been acquired again since the token was issued
+	if keyPair.CreateIndex != token {
+		err = ERROR_STALE_TOKEN
+	}
+	return
+}
+
+// Status queries the lock status of the handle, the same as LockStatus of the locker holding it.
+func (handle *LockHandle) Status() (lockDetail LockDetail, err error) {
+	return readLockStatus(handle.client, handle.Key, handle.SessionID, handle.opts.ExtendLimit)
+}
+
+// Release deletes the lock key and destroys the session, which also stops the renewal.
+// The handles locked together by LockAll share the session, so they are released together.
+// It returns ERROR_LOCK_RELEASED if the lock has already been released or expired.
+func (handle *LockHandle) Release() (err error) {
+	return handle.releaseContext(context.Background())
+}
+
+// releaseContext is the same as Release, the destruction of the session is traced as a child of the context.
+func (handle *LockHandle) releaseContext(ctx context.Context) (err error) {
+	err = ERROR_LOCK_RELEASED
+	handle.releaseOnce.Do(func() {
+		err = handle.doRelease(ctx)
+	})
+	return
+}
+
+// Lost receives the error which stops the renewal, such as ERROR_CANNOT_EXTEND, ERROR_OCCUPY_BY_OTHER,
+// ERROR_SESSION_EXPIRED or ERROR_LOCK_RELEASED when the key is deleted, after which the lock is no longer guaranteed.
+// The loss is only noticed while Extend or AutoExtend is running, and nothing is sent when the lock is released.
+// (锁丢了，赶快停手)
+func (handle *LockHandle) Lost() <-chan error {
+	return handle.lost
+}
+
+// Context returns a context which is cancelled the moment the lock is lost or released,
+// context.Cause tells the error received from Lost, or ERROR_LOCK_RELEASED after the release.
+// Pass it to the protected work, so that it aborts once the ownership is no longer guaranteed.
+func (handle *LockHandle) Context() context.Context {
+	return handle.ctx
+}
+
+// lose reports the error which stops the renewal to Lost and cancels the context, only the first one counts.
+// The locker stops holding the handle, so that the key can be locked again,
+// but keeps it until UnLock or Cancel cleans up the key and the session left behind.
+// The handles locked together share the session, so they are lost together.
+func (handle *LockHandle) lose(err error) {
+	for _, member := range handle.members() {
+		member.loseOne(err)
+	}
+}
+
+// loseOne reports the loss of the handle alone.
+func (handle *LockHandle) loseOne(err error) {
+	handle.loseOnce.Do(func() {
+		handle.opts.logger().Warn("lockz lock lost", "key", handle.Key, "session_id", handle.SessionID, "error", err)
+		handle.forget(STATUS_LOST)
+		handle.lost <- err
+		handle.cancel(err)
+	})
+}
+
+// autoExtend extends the lock until it is released, the loss is reported by Extend itself.
+func (handle *LockHandle) autoExtend() {
+	_ = handle.Extend()
+}
+
+// released tells whether the handle has been released.
+func (handle *LockHandle) released() bool {
+	select {
+	case <-handle.release:
+		return true
+	default:
+		return false
+	}
+}
+
+// doRelease stops the renewal, deletes the lock keys if the session still holds them and destroys the session.
+// A handle is only released once, so the clean-up goes on even if the context is done.
+func (handle *LockHandle) doRelease(ctx context.Context) (err error) {
+	ctx = context.WithoutCancel(ctx)
+	for _, member := range handle.members() {
+		// Stop the renewal and forget the handle
+		close(member.release)
+		member.forget(STATUS_RELEASED)
+		member.cancel(ERROR_LOCK_RELEASED)
+
+		// Delete the lock key first, a key deleted with the session would be kept in the lock delay
+		deleteErr := member.deleteKey(ctx)
+		if err == nil {
+			err = deleteErr
+		}
+		member.opts.logger().Debug("lockz lock released", "key", member.Key, "session_id", member.SessionID, "held", time.Since(member.AcquiredAt))
+	}
+
+	// Destroy the session anyway
+	destroyErr := destroySession(ctx, handle.client, handle.opts, handle.SessionID)
+	if err == nil {
+		err = destroyErr
+	}
+
+	// The client replaced meanwhile is closed after its last lock
+	handle.held.closeIdle()
+	return
+}
+
+// forget removes the handle from the locks held by the locker, a lost one is kept for the clean-up,
+// the hold is measured and the final status is changed to only once.
+func (handle *LockHandle) forget(final Status) {
+	var removed bool
+	if final == STATUS_LOST {
+		removed = handle.held.removeLost(handle)
+	} else {
+		removed = handle.held.remove(handle)
+	}
+	if removed {
+		handle.status.transit(handle.opts, handle.Key, final)
+		handle.opts.metrics().LockReleased(handle.Key, time.Since(handle.AcquiredAt))
+	}
+}
+
+// members lists the handles released together with the handle, itself included.
+func (handle *LockHandle) members() []*LockHandle {
+	if handle.group == nil {
+		return []*LockHandle{handle}
+	}
+	return handle.group
+}
+
+// deleteKey deletes the lock key if it is still held by the session of the handle.
+func (handle *LockHandle) deleteKey(ctx context.Context) (err error) {
+	// Get the key-value pair for the lock
+	var keyPair *KVPair
+	keyPair, err = handle.client.Get(ctx, handle.Key)
+	if err != nil {
+		return
+	}
+
+	// If the key-value pair is nil, the lock has already been released
+	if keyPair == nil {
+		err = ERROR_LOCK_RELEASED
+		return
+	}
+
+	// Unmarshal the lock value JSON to a LockDetail struct
+	var keyValue LockDetail
+	err = json.Unmarshal(keyPair.Value, &keyValue)
+
+	// Check the permission to delete the lcok key
+	if handle.SessionID != keyValue.SessionID {
+		err = ERROR_NO_AUTH_DEL
+		return
+	}
+
+	// Delete the key-value pair to release the lock,
+	// only if it is still the one checked above when the backend supports it
+	if casDeleter, ok := handle.client.(CASDeleter); ok {
+		var deleted bool
+		deleted, err = casDeleter.DeleteCAS(ctx, keyPair)
+		if err == nil && !deleted {
+			err = ERROR_NO_AUTH_DEL
+		}
+		return
+	}
+	err = handle.client.Delete(ctx, handle.Key)
+	return
+}
+
+// heldLocks is the collection of the locks held by a locker, keyed by the lock key.
+// It also keeps the locks lost since, until they are released to clean up their keys and sessions.
+// It is shared by the copies of the locker.
+// The clients replaced by AlterClient are closed once none of the locks uses them anymore.
+type heldLocks struct {
+	mutex   sync.Mutex
+	handles map[string]*LockHandle
+	lost    map[string]*LockHandle
+	retired map[Backend]struct{}
+}
+
+// newHeldLocks creates an empty collection.
+func newHeldLocks() *heldLocks {
+	return &heldLocks{
+		handles: make(map[string]*LockHandle),
+		lost:    make(map[string]*LockHandle),
+		retired: make(map[Backend]struct{}),
+	}
+}
+
+// get finds the handle holding the key, or nil.
+func (held *heldLocks) get(key string) *LockHandle {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	return held.handles[key]
+}
+
+// getLost finds the handle which lost the key and is not released yet, or nil.
+func (held *heldLocks) getLost(key string) *LockHandle {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	return held.lost[key]
+}
+
+// add records the handle, the key acquired again needs no clean-up of the lost one anymore.
+func (held *heldLocks) add(handle *LockHandle) {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	held.handles[handle.Key] = handle
+	delete(held.lost, handle.Key)
+}
+
+// remove forgets the handle, unless the key is held by another handle meanwhile, and tells whether it was forgotten.
+// A lost handle is forgotten as well, but it is not counted.
+func (held *heldLocks) remove(handle *LockHandle) (removed bool) {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	if held.lost[handle.Key] == handle {
+		delete(held.lost, handle.Key)
+	}
+	if held.handles[handle.Key] == handle {
+		delete(held.handles, handle.Key)
+		removed = true
+	}
+	return
+}
+
+// removeLost moves the handle to the lost ones, unless the key is held by another handle meanwhile,
+// and tells whether it was moved.
+func (held *heldLocks) removeLost(handle *LockHandle) (removed bool) {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	if held.handles[handle.Key] == handle {
+		delete(held.handles, handle.Key)
+		held.lost[handle.Key] = handle
+		removed = true
+	}
+	return
+}
+
+// all lists the handles, the lost ones included.
+func (held *heldLocks) all() (handles []*LockHandle) {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	for _, handle := range held.handles {
+		handles = append(handles, handle)
+	}
+	for _, handle := range held.lost {
+		handles = append(handles, handle)
+	}
+	return
+}
+
+// retire closes the client replaced by AlterClient, or keeps it until the locks using it are released.
+func (held *heldLocks) retire(client Backend) {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	if _, ok := client.(io.Closer); !ok {
+		return
+	}
+	held.retired[client] = struct{}{}
+	held.closeUnused()
+}
+
+// closeIdle closes the retired clients which none of the locks uses.
+func (held *heldLocks) closeIdle() {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	held.closeUnused()
+}
+
+// closeUnused closes the retired clients which none of the locks uses. The caller must hold the mutex.
+func (held *heldLocks) closeUnused() {
+	for client := range held.retired {
+		if held.uses(held.handles, client) || held.uses(held.lost, client) {
+			continue
+		}
+		delete(held.retired, client)
+		_ = closeBackend(client)
+	}
+}
+
+// uses tells whether one of the handles uses the client.
+func (held *heldLocks) uses(handles map[string]*LockHandle, client Backend) bool {
+	for _, handle := range handles {
+		if handle.client == client {
+			return true
+		}
+	}
+	return false
+}
+
+// closeRetired closes all the retired clients, even if they are still used.
+func (held *heldLocks) closeRetired() (err error) {
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+
+	for client := range held.retired {
+		delete(held.retired, client)
+		closeErr := closeBackend(client)
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package lockz
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_FencingToken confirms that every new acquisition gets a larger fencing token, and the old one becomes stale.
+func Test_Check_FencingToken(t *testing.T) {
+	// Create two lockers competing for the same key
+	var locker0, locker1 Locker
+	var err error
+	locker0, err = NewLocker(BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+	})
+	require.NoError(t, err)
+	locker1, err = NewLocker(BasicOptions{
+		Driver:     "memory",
+		SessionTTL: 10 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// locker0 acquires the lock, and its token is valid
+	handle0, err := locker0.Lock("fencing_token_test")
+	require.NoError(t, err)
+	require.Equal(t, "fencing_token_test", handle0.Key)
+	require.NotEmpty(t, handle0.SessionID)
+	require.NoError(t, locker1.ValidateToken("fencing_token_test", handle0.Token))
+
+	// Renewing does not change the token
+	err = locker0.Incr("fencing_token_test")
+	require.NoError(t, err)
+	require.NoError(t, locker1.ValidateToken("fencing_token_test", handle0.Token))
+
+	// After the release, the token is no longer valid
+	_, err = locker0.UnLock("fencing_token_test")
+	require.NoError(t, err)
+	require.Equal(t, ERROR_LOCK_RELEASED, locker1.ValidateToken("fencing_token_test", handle0.Token))
+
+	// locker1 acquires the lock with a larger token, the token of locker0 is stale
+	handle1, err := locker1.Lock("fencing_token_test")
+	require.NoError(t, err)
+	require.Greater(t, handle1.Token, handle0.Token)
+	require.Equal(t, ERROR_STALE_TOKEN, locker0.ValidateToken("fencing_token_test", handle0.Token))
+	require.NoError(t, locker0.ValidateToken("fencing_token_test", handle1.Token))
+}
+
+// Test_Check_MultipleHandles confirms that one locker holds two keys at the same time and releases them independently.
+func Test_Check_MultipleHandles(t *testing.T) {
+	// Create new locker with the memory driver
+	locker, err := NewLocker(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 9 * time.Second,
+		ExtendLimit:  10,
+	})
+	require.NoError(t, err)
+
+	// Hold two keys, each with a session of its own
+	handle0, err := locker.Lock("multiple_handles_test0")
+	require.NoError(t, err)
+	handle1, err := locker.Lock("multiple_handles_test1")
+	require.NoError(t, err)
+	require.NotEqual(t, handle0.SessionID, handle1.SessionID)
+
+	// Locking a held key again does not lose it
+	_, err = locker.TryLockOnce("multiple_handles_test0")
+	require.Equal(t, ERROR_ALREADY_HELD, err)
+
+	// Renew the first key until it is released
+	done := make(chan error)
+	go func() {
+		done <- handle0.Extend()
+	}()
+
+	// Releasing the first key stops its renewal and leaves the second one held
+	err = handle0.Release()
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	_, err = handle0.Status()
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+	detail, err := handle1.Status()
+	require.NoError(t, err)
+	require.Equal(t, handle1.SessionID, detail.SessionID)
+
+	// Releasing twice tells it has already been released
+	require.Equal(t, ERROR_LOCK_RELEASED, handle0.Release())
+	_, err = locker.UnLock("multiple_handles_test0")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+	require.Equal(t, ERROR_NOT_HELD, locker.Incr("multiple_handles_test0"))
+
+	// The second key is released through the locker
+	_, err = locker.UnLock("multiple_handles_test1")
+	require.NoError(t, err)
+	_, err = handle1.Status()
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+}
+
+// Test_Check_UnLockLost confirms that UnLock cleans up the key and the session left behind by a lost lock.
+func Test_Check_UnLockLost(t *testing.T) {
+	// Create new locker with the memory driver
+	locker, err := NewLocker(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 20 * time.Millisecond,
+		ExtendLimit:  1,
+	})
+	require.NoError(t, err)
+
+	// The renewal reaches the limit, the key stays with the session which is still alive
+	handle, err := locker.Lock("unlock_lost_test")
+	require.NoError(t, err)
+	require.Equal(t, ERROR_CANNOT_EXTEND, handle.Extend())
+	_, err = handle.Status()
+	require.Equal(t, ERROR_CANNOT_EXTEND, err)
+
+	// The lost lock is released through the locker, only once
+	_, err = locker.UnLock("unlock_lost_test")
+	require.NoError(t, err)
+	_, err = handle.Status()
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+	_, err = locker.UnLock("unlock_lost_test")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+}
@@ -1,72 +1,257 @@
 package lockz
 
 import (
+	"context"
 	"encoding/json"
-	"github.com/hashicorp/consul/api"
+	"errors"
 	"time"
 )
 
 // Lock retries until lock obtained or unknown errors return failure.
-func (locker *Locker) Lock(key string) (acquired bool, err error) {
-	// Destroy any existing session
-	_ = locker.DestroySession()
+func (locker *Locker) Lock(key string) (handle *LockHandle, err error) {
+	return locker.LockContext(context.Background(), key)
+}
+
+// LockContext is the same as Lock, but gives up waiting and returns ctx.Err() once the context is done.
+// The owner named by WithOwner or BasicOptions.OwnerID re-enters the lock it holds, the same handle is returned through the same locker.
+// Otherwise a lock held by another goroutine sharing this locker is waited for, like a lock held by any other locker.
+func (locker *Locker) LockContext(ctx context.Context, key string) (handle *LockHandle, err error) {
+	// Trace the whole acquisition inside the span of the caller
+	_, opts := locker.snapshot()
+	ctx, span := opts.startSpan(ctx, "lockz.Lock", ATTRIBUTE_KEY.String(key))
+	defer func() {
+		if handle != nil {
+			span.SetAttributes(ATTRIBUTE_SESSION_ID.String(handle.SessionID))
+		}
+		endSpan(span, err)
+	}()
+
+	owner := ownerOf(ctx, opts)
+	detail := LockDetail{Mode: MODE_EXCLUSIVE, Owner: owner}
+	for {
+		// The owner re-enters the lock it holds
+		handle, err = locker.reenter(ctx, key, owner)
+		if err == ERROR_ALREADY_HELD {
+			// Held by another goroutine sharing this locker, wait until it lets go
+			err = locker.waitHeld(ctx, key)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if err != ERROR_NOT_HELD {
+			return
+		}
+
+		// Wait in the queue in the fair mode
+		if opts.Fair {
+			handle, err = locker.fairLockContext(ctx, key, detail)
+		} else {
+			handle, err = locker.lockContext(ctx, key, detail)
+		}
+
+		// Another goroutine has just acquired it, re-enter it if it is the same owner or wait for it
+		if err != ERROR_ALREADY_HELD {
+			return
+		}
+	}
+}
+
+// waitHeld waits until the lock held through this locker is released or lost, or the context is done.
+func (locker *Locker) waitHeld(ctx context.Context, key string) (err error) {
+	held := locker.held.get(key)
+	if held == nil {
+		return
+	}
+
+	// The context of the handle is canceled once it is released or lost (释放或丢失后，上下文会被取消)
+	select {
+	case <-held.release:
+	case <-held.Context().Done():
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// lockContext acquires the lock written with the detail, waiting until it is released by others or the context is done.
+func (locker *Locker) lockContext(ctx context.Context, key string, detail LockDetail) (handle *LockHandle, err error) {
+	// Go back to idle if the lock is not acquired, the lock held by another goroutine keeps its status
+	defer func() {
+		if err != nil && err != ERROR_ALREADY_HELD {
+			locker.transit(key, STATUS_IDLE)
+		}
+	}()
+
+	// Do not start anything if the context is already done
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	// The lock is already held by this locker, do not lose it
+	if locker.held.get(key) != nil {
+		err = ERROR_ALREADY_HELD
+		return
+	}
 
 	// If client connection status changed, recreate a client
-	if locker.reEstablish == true {
-		locker.client = nil
-		err = locker.CreateClient()
+	err = locker.reEstablishClient()
+	if err != nil {
+		return
+	}
+
+	for {
+		// Use the same client and options for the whole attempt
+		client, opts := locker.snapshot()
+
+		// Check the lock status
+		_, err = locker.LockStatus(key)
+		switch err {
+		case nil:
+			// Another goroutine sharing this locker has just acquired it
+			err = ERROR_ALREADY_HELD
+			return
+		case ERROR_OCCUPY_BY_OTHER, ERROR_CANNOT_EXTEND:
+			locker.transit(key, STATUS_WAITING)
+			err = locker.BlockOnReleased(ctx, key)
+			if err != ERROR_LOCK_RELEASED {
+				// If there are unknown errors, just directly return the error!
+				return
+			}
+		case ERROR_LOCK_RELEASED:
+			// do not thing!
+		default:
+			// If there are unknown errors, just directly return the error!
+			return
+		}
+
+		// Create a new session
+		var sessionID string
+		sessionID, err = locker.createSession(ctx, client)
 		if err != nil {
 			return
 		}
+
+		// Try to lock
+		handle, err = locker.tryLock(ctx, client, opts, sessionID, key, detail)
+		if err != ERROR_OCCUPY_BY_OTHER {
+			return
+		}
+
+		// Another locker was faster or the lock delay is not over, compete again a little later
+		// (没抢到锁，稍后再抢)
+		select {
+		case <-time.After(DEFAULT_RETRY_INTERVAL):
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
 	}
+}
 
-	// Check the lock status
-	_, err = locker.LockStatus(key)
-	switch err {
-	case ERROR_OCCUPY_BY_OTHER, ERROR_CANNOT_EXTEND:
-		err = locker.BlockOnReleased(key)
-		if err != ERROR_LOCK_RELEASED {
-			// If there are unknown errors, just directly return the error!
+// TryLockOnce tries to acquire the lock once with a session of its own, and returns immediately.
+// It returns ERROR_OCCUPY_BY_OTHER if the lock is held by others, or still in the lock delay.
+// (只抢一次，抢不到就走)
+// Like Lock, the owner named by BasicOptions.OwnerID re-enters the lock it holds.
+func (locker *Locker) TryLockOnce(key string) (handle *LockHandle, err error) {
+	ctx := context.Background()
+	_, opts := locker.snapshot()
+	for {
+		// The owner re-enters the lock it holds
+		handle, err = locker.reenter(ctx, key, opts.OwnerID)
+		if err != ERROR_NOT_HELD {
 			return
 		}
-	case ERROR_LOCK_RELEASED:
-		// do not thing!
-	default:
-		// If there are unknown errors, just directly return the error!
+
+		handle, err = locker.tryLockOnce(ctx, key, LockDetail{Mode: MODE_EXCLUSIVE, Owner: opts.OwnerID})
+		if err != ERROR_ALREADY_HELD {
+			return
+		}
+	}
+}
+
+// tryLockOnce tries to acquire the lock written with the detail once with a session of its own.
+func (locker *Locker) tryLockOnce(ctx context.Context, key string, detail LockDetail) (handle *LockHandle, err error) {
+	// The lock is already held by this locker, do not lose it
+	if locker.held.get(key) != nil {
+		err = ERROR_ALREADY_HELD
 		return
 	}
 
+	// If client connection status changed, recreate a client
+	err = locker.reEstablishClient()
+	if err != nil {
+		return
+	}
+	client, opts := locker.snapshot()
+
 	// Create a new session
-	err = locker.NewSession()
+	var sessionID string
+	sessionID, err = locker.createSession(ctx, client)
 	if err != nil {
 		return
 	}
 
-	// Try to lock
-	return locker.TryLock(key)
+	// Try to lock, the session is destroyed if it fails
+	return locker.tryLock(ctx, client, opts, sessionID, key, detail)
+}
+
+// LockWithTimeout is the same as Lock, but waits at most the timeout,
+// and returns ERROR_LOCK_TIMEOUT so that the caller can fall back instead of hanging.
+func (locker *Locker) LockWithTimeout(key string, timeout time.Duration) (handle *LockHandle, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	handle, err = locker.LockContext(ctx, key)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ERROR_LOCK_TIMEOUT
+	}
+	return
 }
 
-// UnLock releases the distributed locks.
+// UnLock releases the distributed lock held by this locker.
+// A re-entered lock is only released by the UnLock matching the first Lock.
+// A lock lost by this locker is released as well, which deletes its key if the session still holds it and destroys the session.
 func (locker *Locker) UnLock(key string) (acquired bool, err error) {
-	// Get the key-value pair for the lock
-	var keyPair *api.KVPair
-	keyPair, _, err = locker.client.KV().Get(key, nil)
-	if err != nil {
+	return locker.UnLockContext(context.Background(), key)
+}
+
+// UnLockContext is the same as UnLock, but traces the release inside the span of the context.
+func (locker *Locker) UnLockContext(ctx context.Context, key string) (acquired bool, err error) {
+	_, opts := locker.snapshot()
+	ctx, span := opts.startSpan(ctx, "lockz.UnLock", ATTRIBUTE_KEY.String(key))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	// Take one hold back through its handle
+	handle := locker.held.get(key)
+	if handle != nil {
+		span.SetAttributes(ATTRIBUTE_SESSION_ID.String(handle.SessionID))
+		err = handle.leave(ctx)
 		return
 	}
 
-	// Unmarshal the lock value JSON to a LockDetail struct
-	var keyValue LockDetail
-	err = json.Unmarshal(keyPair.Value, &keyValue)
-
-	// Check the permission to delete the lcok key
-	if locker.sessionID != keyValue.SessionID {
-		err = ERROR_NO_AUTH_DEL
+	// Lost by this locker, clean up the key and the session left behind
+	if handle = locker.held.getLost(key); handle != nil {
+		span.SetAttributes(ATTRIBUTE_SESSION_ID.String(handle.SessionID))
+		err = handle.releaseContext(ctx)
 		return
 	}
 
-	// Delete the key-value pair to release the lock
-	_, err = locker.client.KV().Delete(key, nil)
+	// Not held by this locker, tell whether the lock exists at all
+	client, _ := locker.snapshot()
+	var keyPair *KVPair
+	keyPair, err = client.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	if keyPair == nil {
+		err = ERROR_LOCK_RELEASED
+		return
+	}
+	err = ERROR_NO_AUTH_DEL
 
 	// Return released status and no error on success
 	return
@@ -74,9 +259,21 @@ func (locker *Locker) UnLock(key string) (acquired bool, err error) {
 
 // LockStatus queries lock status, validating ownership and limits not exceeded util the lock
 func (locker *Locker) LockStatus(key string) (lockDetail LockDetail, err error) {
+	// Compare with the session holding the key in this locker, if any
+	var sessionID string
+	if handle := locker.held.get(key); handle != nil {
+		sessionID = handle.SessionID
+	}
+
+	client, opts := locker.snapshot()
+	return readLockStatus(client, key, sessionID, opts.ExtendLimit)
+}
+
+// readLockStatus reads the lock, validating it is held by the session and the extend limit is not reached.
+func readLockStatus(client Backend, key string, sessionID string, extendLimit int) (lockDetail LockDetail, err error) {
 	// Get the key-value pair for the key
-	var keyPair *api.KVPair
-	keyPair, _, err = locker.client.KV().Get(key, nil)
+	var keyPair *KVPair
+	keyPair, err = client.Get(context.Background(), key)
 	if err != nil {
 		return
 	}
@@ -96,13 +293,13 @@ func (locker *Locker) LockStatus(key string) (lockDetail LockDetail, err error)
 
 	// If the lock has been extended beyond the limit, return ERROR_CANNOT_EXTEND.
 	// (Unlock soon, ready to grab the lock !)
-	if lockDetail.Extend >= locker.Opts.Basic.ExtendLimit {
+	if lockDetail.Extend >= extendLimit {
 		err = ERROR_CANNOT_EXTEND
 	}
 
 	// If the lock session ID does not match, return error
 	// (The lock is still occupied, just wait !)
-	if lockDetail.SessionID != locker.sessionID {
+	if lockDetail.SessionID != sessionID {
 		err = ERROR_OCCUPY_BY_OTHER
 	}
 
@@ -110,25 +307,34 @@ func (locker *Locker) LockStatus(key string) (lockDetail LockDetail, err error)
 	return
 }
 
-// BlockOnReleased queries key repeatedly, blocking until release the distributed lock
-func (locker *Locker) BlockOnReleased(key string) (err error) {
-	// Declare variables to hold the key-value pair and query metadata
-	var keyPair *api.KVPair
-	var queryMeta *api.QueryMeta
-
-	// Start blocking
-	q := &api.QueryOptions{WaitIndex: 0}
+// BlockOnReleased queries key repeatedly, blocking until release the distributed lock or the context is done.
+func (locker *Locker) BlockOnReleased(ctx context.Context, key string) (err error) {
+	// Declare variables to hold the key-value pair and the index to wait on
+	var keyPair *KVPair
+	var waitIndex uint64
 
-	// Set the status to STATUS_BLOCK_ON_RELEASE
-	locker.status = STATUS_BLOCK_ON_RELEASE
+	// Measure and trace the time spent waiting, the release is the expected outcome
+	client, opts := locker.snapshot()
+	start := time.Now()
+	_, span := opts.startSpan(ctx, "lockz.BlockOnReleased", ATTRIBUTE_KEY.String(key))
+	defer func() {
+		opts.metrics().ObserveWait(key, time.Since(start))
+		opts.logger().Debug("lockz waited for the release", "key", key, "waited", time.Since(start), "outcome", ErrorLabel(err))
+		if err == ERROR_LOCK_RELEASED {
+			endSpan(span, nil)
+			return
+		}
+		endSpan(span, err)
+	}()
 
 	for {
-		// Get the key-value pair and query metadata from the key
-		keyPair, queryMeta, err = locker.client.KV().Get(key, q)
+		// Watch the key until it changes after the wait index
+		// (The context aborts the blocking query !)
+		keyPair, waitIndex, err = client.Watch(ctx, key, waitIndex)
 
-		// If no key-value pair is returned, the lock has been released. Return ERROR_LOCK_RELEASED.
-		if keyPair == nil {
-			err = ERROR_LOCK_RELEASED
+		// If the context is done, stop waiting and return the context error
+		if ctx.Err() != nil {
+			err = ctx.Err()
 			return
 		}
 		// Return any other error
@@ -136,19 +342,59 @@ func (locker *Locker) BlockOnReleased(key string) (err error) {
 			return
 		}
 
-		// Update the wait index to the latest for the next query
-		q.WaitIndex = queryMeta.LastIndex
+		// If no key-value pair is returned, the lock has been released. Return ERROR_LOCK_RELEASED.
+		if keyPair == nil {
+			err = ERROR_LOCK_RELEASED
+			return
+		}
 	}
 }
 
-// TryLock attempts to acquire a lock using a session ID and a key
-func (locker *Locker) TryLock(key string) (acquired bool, err error) {
+// TryLock attempts to acquire a lock using a session ID and a key, it returns ERROR_OCCUPY_BY_OTHER if the lock is not acquired.
+// The session is destroyed if the lock is not acquired, otherwise it belongs to the returned handle.
+func (locker *Locker) TryLock(sessionID string, key string) (handle *LockHandle, err error) {
+	client, opts := locker.snapshot()
+	return locker.tryLock(context.Background(), client, opts, sessionID, key, LockDetail{Mode: MODE_EXCLUSIVE})
+}
+
+// tryLock attempts to acquire a lock with the session created on the client,
+// the detail carries the fields chosen by the caller, such as the mode.
+// The session is destroyed if the lock is not acquired, even once the context is done.
+func (locker *Locker) tryLock(ctx context.Context, client Backend, opts BasicOptions, sessionID string, key string, detail LockDetail) (handle *LockHandle, err error) {
+	handle, err = locker.acquire(ctx, client, opts, sessionID, key, detail)
+	if err != nil {
+		_ = destroySession(context.WithoutCancel(ctx), client, opts, sessionID)
+	}
+	return
+}
+
+// acquire attempts to acquire a lock with the session, which is kept even if the lock is not acquired.
+// The attempt is traced as a child of the context, which aborts it as well.
+func (locker *Locker) acquire(ctx context.Context, client Backend, opts BasicOptions, sessionID string, key string, detail LockDetail) (handle *LockHandle, err error) {
+	// Measure and trace the attempt, which goes back to idle on failure
+	start := time.Now()
+	ctx, span := opts.startSpan(ctx, "lockz.TryLock", ATTRIBUTE_KEY.String(key), ATTRIBUTE_SESSION_ID.String(sessionID))
+	locker.status.transit(opts, key, STATUS_COMPETING)
+	defer func() {
+		opts.metrics().ObserveAcquire(key, time.Since(start), err)
+		endSpan(span, err)
+		if err != nil {
+			opts.logger().Debug("lockz lock not acquired", "key", key, "session_id", sessionID, "outcome", ErrorLabel(err))
+			locker.status.transit(opts, key, STATUS_IDLE)
+		}
+	}()
+
 	// Define the LockDetails struct
-	value := LockDetail{
-		SessionID:  locker.sessionID,
-		Extend:     0,
-		UpdateTime: time.Now(),
+	value := detail
+	value.Version = LOCK_DETAIL_VERSION
+	value.SessionID = sessionID
+	if value.Owner == "" {
+		value.Owner = opts.OwnerID
 	}
+	value.HoldCount = 1
+	value.Extend = 0
+	value.UpdateTime = time.Now()
+	value.OwnerInfo = newOwnerInfo(opts, value.UpdateTime)
 
 	// Marshal the struct to JSON bytes
 	b, err := json.Marshal(value)
@@ -157,23 +403,37 @@ func (locker *Locker) TryLock(key string) (acquired bool, err error) {
 	}
 
 	// Use the session to acquire a locker
-	lockOpts := &api.KVPair{
+	lockOpts := &KVPair{
 		Key:     key,
 		Value:   b,
-		Session: locker.sessionID,
+		Session: sessionID,
 	}
 
 	// Try acquiring the lock using the session
-	acquired, _, err = locker.client.KV().Acquire(lockOpts, nil)
+	acquired, err := client.Acquire(ctx, lockOpts)
 	if err != nil {
 		return
 	}
 
-	// If the lock acquisition fails, delete the session immediately.
+	// The lock is held by others
 	if acquired == false {
-		_ = locker.DestroySession()
+		err = ERROR_OCCUPY_BY_OTHER
+		return
 	}
 
-	// Return acquired status and no error on success
+	// Read the lock back for its fencing token
+	var keyPair *KVPair
+	keyPair, err = client.Get(ctx, key)
+	if err == nil && keyPair == nil {
+		err = ERROR_LOCK_RELEASED
+	}
+	if err != nil {
+		return
+	}
+	// Return the handle and no error on success
+	handle = locker.newHandle(client, opts, sessionID, key, keyPair.CreateIndex, value.UpdateTime)
+	handle.owner = value.Owner
+	locker.hold(handle)
+	opts.logger().Debug("lockz lock acquired", "key", key, "session_id", sessionID, "token", handle.Token)
 	return
 }
@@ -1,6 +1,7 @@
 package lockz
 
 import (
+	"context"
 	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
@@ -12,15 +13,14 @@ func Test_Check_Lock(t *testing.T) {
 	var locker0, locker1 Locker
 	var err error
 	locker0, err = NewLocker(BasicOptions{
-		Driver:        "consul",
-		IpAddressPort: TestConsulIPPort,
-		SessionTTL:    3 * time.Second,
+		Driver:     "memory",
+		SessionTTL: 3 * time.Second,
 	})
 	require.NoError(t, err)
 
 	// Create a new locker with a session TTL of 3 seconds
 	locker1, err = NewLocker(BasicOptions{
-		Driver:     "consul",
+		Driver:     "memory",
 		SessionTTL: 3 * time.Second,
 	})
 	require.NoError(t, err)
@@ -28,18 +28,323 @@ func Test_Check_Lock(t *testing.T) {
 	// Loop 10 times
 	for i := 0; i < 10; i++ {
 		// Acquire a lock on locker0
-		var acquired bool
-		acquired, err = locker0.Lock("lock_test")
+		var handle *LockHandle
+		handle, err = locker0.Lock("lock_test")
 		require.NoError(t, err)
-		require.True(t, acquired)
+		require.NotNil(t, handle)
 		// Unlock locker0
 		_, _ = locker0.UnLock("lock_test")
 
 		// Acquire a lock on locker1
-		acquired, err = locker1.Lock("lock_test")
+		handle, err = locker1.Lock("lock_test")
 		require.NoError(t, err)
-		require.True(t, acquired)
+		require.NotNil(t, handle)
 		// Unlock locker1
 		_, _ = locker1.UnLock("lock_test")
 	}
 }
+
+// Test_Check_LockContext confirms that a waiting Lock gives up once its context is done.
+func Test_Check_LockContext(t *testing.T) {
+	// Create two lockers competing for the same key
+	var locker0, locker1 Locker
+	var err error
+	locker0, err = NewLocker(BasicOptions{
+		Driver:     "memory",
+		SessionTTL: 10 * time.Second,
+	})
+	require.NoError(t, err)
+	locker1, err = NewLocker(BasicOptions{
+		Driver:     "memory",
+		SessionTTL: 10 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// locker0 holds the lock
+	var handle *LockHandle
+	handle, err = locker0.Lock("lock_context_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+	defer func() {
+		_, _ = locker0.UnLock("lock_context_test")
+	}()
+
+	// locker1 waits, but only for 1 second
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	handle, err = locker1.LockContext(ctx, "lock_context_test")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Nil(t, handle)
+
+	// A context that is already done does not even start
+	handle, err = locker1.LockContext(ctx, "lock_context_test")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Nil(t, handle)
+}
+
+// Test_Check_TryLockOnce confirms that TryLockOnce and LockWithTimeout return instead of hanging on a held lock.
+func Test_Check_TryLockOnce(t *testing.T) {
+	// Create two lockers competing for the same key
+	var locker0, locker1 Locker
+	var err error
+	locker0, err = NewLocker(BasicOptions{
+		Driver:     "memory",
+		SessionTTL: 10 * time.Second,
+	})
+	require.NoError(t, err)
+	locker1, err = NewLocker(BasicOptions{
+		Driver:     "memory",
+		SessionTTL: 10 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// locker0 gets the free lock at once
+	handle0, err := locker0.TryLockOnce("try_lock_once_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle0)
+	_, err = locker0.TryLockOnce("try_lock_once_test")
+	require.Equal(t, ERROR_ALREADY_HELD, err)
+
+	// locker1 gives up at once
+	handle1, err := locker1.TryLockOnce("try_lock_once_test")
+	require.Equal(t, ERROR_OCCUPY_BY_OTHER, err)
+	require.Nil(t, handle1)
+
+	// locker1 gives up after the timeout
+	start := time.Now()
+	handle1, err = locker1.LockWithTimeout("try_lock_once_test", 300*time.Millisecond)
+	require.Equal(t, ERROR_LOCK_TIMEOUT, err)
+	require.Nil(t, handle1)
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	// locker1 gets the lock within the timeout once locker0 releases it
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = handle0.Release()
+	}()
+	handle1, err = locker1.LockWithTimeout("try_lock_once_test", 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, handle1.Release())
+}
+
+// Test_Check_SharedTimeout confirms that LockWithTimeout waits for another goroutine sharing the locker,
+// instead of returning at once.
+func Test_Check_SharedTimeout(t *testing.T) {
+	locker, err := NewLocker(BasicOptions{
+		Driver:     "memory",
+		SessionTTL: 10 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// Another goroutine holds the key until it is told to let go
+	locked := make(chan error)
+	unlock := make(chan struct{})
+	released := make(chan error)
+	go func() {
+		handle, err := locker.Lock("shared_timeout_test")
+		locked <- err
+		if err != nil {
+			return
+		}
+		<-unlock
+		released <- handle.Release()
+	}()
+	require.NoError(t, <-locked)
+
+	// The key is waited for until the timeout
+	start := time.Now()
+	handle, err := locker.LockWithTimeout("shared_timeout_test", 300*time.Millisecond)
+	require.Equal(t, ERROR_LOCK_TIMEOUT, err)
+	require.Nil(t, handle)
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	// And got once the other goroutine releases it
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(unlock)
+	}()
+	handle, err = locker.LockWithTimeout("shared_timeout_test", 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, <-released)
+	require.NoError(t, handle.Release())
+}
+
+// Test_Check_FairLock confirms that the contenders in the fair mode get the lock in the order of arrival.
+func Test_Check_FairLock(t *testing.T) {
+	opts := BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+		Fair:        true,
+	}
+
+	// The first locker holds the lock
+	holder, err := NewLocker(opts)
+	require.NoError(t, err)
+	handle, err := holder.Lock("fair_lock_test")
+	require.NoError(t, err)
+
+	// The contenders arrive one by one
+	order := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		contender, err := NewLocker(opts)
+		require.NoError(t, err)
+		go func(i int, contender Locker) {
+			handle, err := contender.Lock("fair_lock_test")
+			require.NoError(t, err)
+			order <- i
+			time.Sleep(20 * time.Millisecond)
+			require.NoError(t, handle.Release())
+		}(i, contender)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// They get the lock in the same order after the release
+	require.NoError(t, handle.Release())
+	for i := 0; i < 4; i++ {
+		select {
+		case got := <-order:
+			require.Equal(t, i, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the queue is stuck")
+		}
+	}
+
+	// A contender giving up leaves the queue
+	handle, err = holder.Lock("fair_lock_test")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	contender, err := NewLocker(opts)
+	require.NoError(t, err)
+	_, err = contender.LockContext(ctx, "fair_lock_test")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	pairs, err := holder.client.(Lister).List(context.Background(), queuePrefix("fair_lock_test"))
+	require.NoError(t, err)
+	require.Empty(t, pairs)
+	require.NoError(t, handle.Release())
+}
+
+// Test_Check_FairWait confirms that the session of a contender in the queue outlives a wait longer than its TTL.
+func Test_Check_FairWait(t *testing.T) {
+	opts := BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   time.Second,
+		ExtendPeriod: 200 * time.Millisecond,
+		ExtendLimit:  100,
+		Fair:         true,
+	}
+	holder, err := NewLocker(opts)
+	require.NoError(t, err)
+	handle, err := holder.Lock("fair_wait_test")
+	require.NoError(t, err)
+	go func() {
+		_ = handle.Extend()
+	}()
+
+	// The contender waits longer than the TTL of its session, and still gets the lock
+	contender, err := NewLocker(opts)
+	require.NoError(t, err)
+	acquired := make(chan error)
+	go func() {
+		_, err := contender.Lock("fair_wait_test")
+		acquired <- err
+	}()
+	time.Sleep(2500 * time.Millisecond)
+	require.NoError(t, handle.Release())
+	select {
+	case err = <-acquired:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queue is stuck")
+	}
+	_, err = contender.UnLock("fair_wait_test")
+	require.NoError(t, err)
+}
+
+// Test_Check_ReentrantLock confirms that the owner re-enters the lock it holds,
+// and only the UnLock matching the first Lock releases it.
+func Test_Check_ReentrantLock(t *testing.T) {
+	opts := BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+	}
+	opts.OwnerID = "owner-0"
+	owner, err := NewLocker(opts)
+	require.NoError(t, err)
+	opts.OwnerID = ""
+	other, err := NewLocker(opts)
+	require.NoError(t, err)
+
+	// The nested Lock returns the same handle and counts the holds
+	handle, err := owner.Lock("reentrant_lock_test")
+	require.NoError(t, err)
+	again, err := owner.Lock("reentrant_lock_test")
+	require.NoError(t, err)
+	require.Same(t, handle, again)
+	detail, err := handle.Status()
+	require.NoError(t, err)
+	require.Equal(t, "owner-0", detail.Owner)
+	require.Equal(t, 2, detail.HoldCount)
+
+	// Others cannot take it
+	_, err = other.TryLockOnce("reentrant_lock_test")
+	require.Equal(t, ERROR_OCCUPY_BY_OTHER, err)
+
+	// The nested UnLock keeps the lock with the same session
+	_, err = owner.UnLock("reentrant_lock_test")
+	require.NoError(t, err)
+	detail, err = handle.Status()
+	require.NoError(t, err)
+	require.Equal(t, 1, detail.HoldCount)
+	require.Equal(t, handle.SessionID, detail.SessionID)
+
+	// The last UnLock releases it
+	_, err = owner.UnLock("reentrant_lock_test")
+	require.NoError(t, err)
+	_, err = handle.Status()
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+	_, err = owner.UnLock("reentrant_lock_test")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+
+	// Another locker of the same owner does not re-enter it
+	opts.OwnerID = "owner-0"
+	twin, err := NewLocker(opts)
+	require.NoError(t, err)
+	handle, err = owner.Lock("reentrant_lock_test")
+	require.NoError(t, err)
+	_, err = twin.TryLockOnce("reentrant_lock_test")
+	require.Equal(t, ERROR_OCCUPY_BY_OTHER, err)
+	_, err = owner.UnLock("reentrant_lock_test")
+	require.NoError(t, err)
+	_, err = handle.Status()
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+}
+
+// Test_Check_ReentrantOwner confirms that the goroutines sharing a locker re-enter a lock only when they name the same owner.
+func Test_Check_ReentrantOwner(t *testing.T) {
+	locker, err := NewLocker(BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+	})
+	require.NoError(t, err)
+
+	// The owner named in the context re-enters the lock
+	ctx := WithOwner(context.Background(), "request-0")
+	handle, err := locker.LockContext(ctx, "reentrant_owner_test")
+	require.NoError(t, err)
+	again, err := locker.LockContext(ctx, "reentrant_owner_test")
+	require.NoError(t, err)
+	require.Same(t, handle, again)
+
+	// Another owner and a call without owner wait for it instead
+	ctx, cancel := context.WithTimeout(WithOwner(context.Background(), "request-1"), 100*time.Millisecond)
+	defer cancel()
+	_, err = locker.LockContext(ctx, "reentrant_owner_test")
+	require.Equal(t, context.DeadlineExceeded, err)
+	_, err = locker.TryLockOnce("reentrant_owner_test")
+	require.Equal(t, ERROR_ALREADY_HELD, err)
+	require.NoError(t, handle.Release())
+}
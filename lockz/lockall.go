@@ -0,0 +1,206 @@
+package lockz
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// LockAll acquires all the keys at once in one transaction, or none of them, so that
+// lockers taking overlapping keys in different orders never deadlock.
+// The keys share one session, releasing any of the returned handles releases all of them.
+// It returns ERROR_NO_KEYS if no key is given.
+// The backend of the driver must implement TxnAcquirer.
+// (要么全拿，要么全不拿)
+func (locker *Locker) LockAll(keys []string) (handles []*LockHandle, err error) {
+	return locker.LockAllContext(context.Background(), keys)
+}
+
+// LockAllContext is the same as LockAll, but gives up waiting and returns ctx.Err() once the context is done.
+func (locker *Locker) LockAllContext(ctx context.Context, keys []string) (handles []*LockHandle, err error) {
+	// Go back to idle if the keys are not acquired, the keys held already keep their status
+	defer func() {
+		if err != nil && err != ERROR_ALREADY_HELD {
+			for _, key := range keys {
+				locker.transit(key, STATUS_IDLE)
+			}
+		}
+	}()
+
+	// Do not start anything if the context is already done
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	// Take every key only once, and none already held by this locker
+	keys = uniqueKeys(keys)
+	if len(keys) == 0 {
+		err = ERROR_NO_KEYS
+		return
+	}
+	for _, key := range keys {
+		if locker.held.get(key) != nil {
+			err = ERROR_ALREADY_HELD
+			return
+		}
+	}
+
+	// If client connection status changed, recreate a client
+	err = locker.reEstablishClient()
+	if err != nil {
+		return
+	}
+
+	for {
+		// Use the same client and options for the whole attempt
+		client, opts := locker.snapshot()
+		acquirer, ok := client.(TxnAcquirer)
+		if !ok {
+			err = ERROR_CANNOT_TXN
+			return
+		}
+
+		// Wait for every key to be released
+		for _, key := range keys {
+			locker.transit(key, STATUS_WAITING)
+			err = locker.BlockOnReleased(ctx, key)
+			if err != ERROR_LOCK_RELEASED {
+				return
+			}
+		}
+
+		// Create a new session shared by all the keys
+		var sessionID string
+		sessionID, err = locker.createSession(ctx, client)
+		if err != nil {
+			return
+		}
+
+		// Try to lock all of them, the session is destroyed if it fails
+		handles, err = locker.acquireAll(client, acquirer, opts, sessionID, keys)
+		if err != nil {
+			_ = destroySession(context.WithoutCancel(ctx), client, opts, sessionID)
+		}
+		if err != ERROR_OCCUPY_BY_OTHER {
+			return
+		}
+
+		// Another locker took one of them meanwhile, compete again a little later
+		select {
+		case <-time.After(DEFAULT_RETRY_INTERVAL):
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// UnLockAll releases the keys locked together by LockAll.
+// It returns ERROR_NOT_HELD if one of the keys is not held by this locker.
+func (locker *Locker) UnLockAll(keys []string) (err error) {
+	// Find all the handles first, releasing one of them forgets the whole group
+	var handles []*LockHandle
+	for _, key := range uniqueKeys(keys) {
+		handle := locker.held.get(key)
+		if handle == nil {
+			err = ERROR_NOT_HELD
+			continue
+		}
+		handles = append(handles, handle)
+	}
+
+	for _, handle := range handles {
+		// The whole group goes with the first handle
+		if handle.released() {
+			continue
+		}
+		releaseErr := handle.Release()
+		if err == nil {
+			err = releaseErr
+		}
+	}
+	return
+}
+
+// acquireAll attempts to acquire all the keys with the session in one transaction,
+// the session is kept even if the keys are not acquired.
+func (locker *Locker) acquireAll(client Backend, acquirer TxnAcquirer, opts BasicOptions, sessionID string, keys []string) (handles []*LockHandle, err error) {
+	// Measure the attempt for every key, which goes back to idle on failure
+	start := time.Now()
+	for _, key := range keys {
+		locker.status.transit(opts, key, STATUS_COMPETING)
+	}
+	defer func() {
+		for _, key := range keys {
+			opts.metrics().ObserveAcquire(key, time.Since(start), err)
+			if err != nil {
+				locker.status.transit(opts, key, STATUS_IDLE)
+			}
+		}
+	}()
+
+	// Every key is written with the same LockDetail
+	value := LockDetail{
+		Version:    LOCK_DETAIL_VERSION,
+		SessionID:  sessionID,
+		Mode:       MODE_EXCLUSIVE,
+		Owner:      opts.OwnerID,
+		HoldCount:  1,
+		UpdateTime: time.Now(),
+	}
+	value.OwnerInfo = newOwnerInfo(opts, value.UpdateTime)
+	b, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	pairs := make([]*KVPair, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, &KVPair{Key: key, Value: b, Session: sessionID})
+	}
+
+	// Try acquiring all of them at once
+	acquired, err := acquirer.AcquireAll(context.Background(), pairs)
+	if err != nil {
+		return
+	}
+	if acquired == false {
+		err = ERROR_OCCUPY_BY_OTHER
+		return
+	}
+
+	// Read the locks back for their fencing tokens
+	for _, key := range keys {
+		var keyPair *KVPair
+		keyPair, err = client.Get(context.Background(), key)
+		if err == nil && keyPair == nil {
+			err = ERROR_LOCK_RELEASED
+		}
+		if err != nil {
+			handles = nil
+			return
+		}
+		handles = append(handles, locker.newHandle(client, opts, sessionID, key, keyPair.CreateIndex, value.UpdateTime))
+	}
+
+	// The handles are released together
+	for _, handle := range handles {
+		handle.releaseOnce = handles[0].releaseOnce
+		handle.group = handles
+	}
+	locker.hold(handles...)
+	opts.logger().Debug("lockz locks acquired", "keys", keys, "session_id", sessionID)
+	return
+}
+
+// uniqueKeys drops the repeated keys, keeping the order.
+func uniqueKeys(keys []string) (unique []string) {
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package lockz
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_LockAll confirms that the keys are acquired all at once or none of them,
+// and that they are released together.
+func Test_Check_LockAll(t *testing.T) {
+	opts := BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+	}
+	locker0, err := NewLocker(opts)
+	require.NoError(t, err)
+	locker1, err := NewLocker(opts)
+	require.NoError(t, err)
+
+	// One of the keys is held by the other locker, so nothing is acquired
+	handle1, err := locker1.Lock("lock_all_test_b")
+	require.NoError(t, err)
+	timeout, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = locker0.LockAllContext(timeout, []string{"lock_all_test_a", "lock_all_test_b"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = locker1.LockStatus("lock_all_test_a")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+
+	// All of them are acquired with the same session once the key is released
+	require.NoError(t, handle1.Release())
+	handles, err := locker0.LockAll([]string{"lock_all_test_a", "lock_all_test_b", "lock_all_test_a"})
+	require.NoError(t, err)
+	require.Len(t, handles, 2)
+	require.Equal(t, handles[0].SessionID, handles[1].SessionID)
+	_, err = locker0.LockAll([]string{"lock_all_test_b"})
+	require.Equal(t, ERROR_ALREADY_HELD, err)
+	_, err = locker0.LockAll(nil)
+	require.Equal(t, ERROR_NO_KEYS, err)
+
+	// Releasing one of the handles releases all of them
+	require.NoError(t, handles[1].Release())
+	require.Equal(t, ERROR_LOCK_RELEASED, handles[0].Release())
+	_, err = locker1.LockStatus("lock_all_test_a")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+
+	// UnLockAll releases the keys locked together
+	_, err = locker0.LockAll([]string{"lock_all_test_a", "lock_all_test_b"})
+	require.NoError(t, err)
+	require.NoError(t, locker0.UnLockAll([]string{"lock_all_test_a", "lock_all_test_b"}))
+	require.Equal(t, ERROR_NOT_HELD, locker0.UnLockAll([]string{"lock_all_test_a"}))
+}
+
+// Test_Check_LockAllExtend confirms that the keys locked together are renewed as one, and lost together.
+func Test_Check_LockAllExtend(t *testing.T) {
+	locker, err := NewLocker(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 20 * time.Millisecond,
+		ExtendLimit:  3,
+	})
+	require.NoError(t, err)
+	handles, err := locker.LockAll([]string{"lock_all_extend_test_a", "lock_all_extend_test_b"})
+	require.NoError(t, err)
+
+	// One handle renews every key until the limit, then all of them are lost
+	go func() {
+		_ = handles[0].Extend()
+	}()
+	for _, handle := range handles {
+		select {
+		case err = <-handle.Lost():
+			require.Equal(t, ERROR_CANNOT_EXTEND, err)
+		case <-time.After(time.Second):
+			t.Fatal("the lock is never lost", handle.Key)
+		}
+		require.Error(t, handle.Context().Err())
+		detail, err := locker.client.Get(context.Background(), handle.Key)
+		require.NoError(t, err)
+		require.Contains(t, string(detail.Value), `"extend":3`)
+	}
+
+	// Either key cleans up the whole group
+	_, err = locker.UnLock("lock_all_extend_test_b")
+	require.NoError(t, err)
+	_, err = locker.UnLock("lock_all_extend_test_a")
+	require.Equal(t, ERROR_LOCK_RELEASED, err)
+}
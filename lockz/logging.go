@@ -0,0 +1,33 @@
+package lockz
+
+import (
+	"context"
+	"log/slog"
+)
+
+// The lock operations log through BasicOptions.Logger at these levels:
+// Debug for the usual lifecycle, such as the sessions, the acquisitions, the contention, the renewals, the releases and the status transitions,
+// Info for the client re-established after AlterClient and the locks broken by the operator,
+// Warn for the lost locks and the sessions which could not be created or destroyed, which used to be discarded silently,
+// Error for the client which could not be re-established.
+// Nothing is logged if the logger is nil.
+// (出事了要看得到)
+
+// nopLogger drops all the records.
+var nopLogger = slog.New(discardHandler{})
+
+// logger returns the logger of the options, or one dropping everything.
+func (opts BasicOptions) logger() *slog.Logger {
+	if opts.Logger == nil {
+		return nopLogger
+	}
+	return opts.Logger
+}
+
+// discardHandler is the slog.Handler dropping all the records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool   { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error  { return nil }
+func (handler discardHandler) WithAttrs([]slog.Attr) slog.Handler { return handler }
+func (handler discardHandler) WithGroup(string) slog.Handler      { return handler }
@@ -0,0 +1,82 @@
+package lockz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a buffer written by the logger from several goroutines.
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+// levels maps the logged messages to their levels.
+func (b *syncBuffer) levels(t *testing.T) (levels map[string]string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	levels = make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(b.buffer.String()), "\n") {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		levels[record["msg"].(string)] = record["level"].(string)
+	}
+	return
+}
+
+// Test_Check_Logging confirms that the lifecycle and the failures of the locks are logged at their levels.
+func Test_Check_Logging(t *testing.T) {
+	output := new(syncBuffer)
+	opts := BasicOptions{
+		Driver:        "memory",
+		IpAddressPort: "127.0.0.1:8023",
+		SessionTTL:    10 * time.Second,
+		ExtendPeriod:  50 * time.Millisecond,
+		ExtendLimit:   10,
+		Logger:        slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	locker, err := NewLocker(opts)
+	require.NoError(t, err)
+
+	// Lock, renew and release after switching the client
+	require.NoError(t, locker.AlterClient("127.0.0.1:8023"))
+	handle, err := locker.Lock("logging_test")
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(80 * time.Millisecond)
+		_ = handle.Release()
+	}()
+	require.NoError(t, handle.Extend())
+
+	// The lock is lost once it is broken
+	handle, err = locker.Lock("logging_test")
+	require.NoError(t, err)
+	_, err = BreakLock(context.Background(), opts, "logging_test", "test")
+	require.NoError(t, err)
+	require.Error(t, handle.Extend())
+
+	levels := output.levels(t)
+	require.Equal(t, "INFO", levels["lockz client re-established"])
+	require.Equal(t, "DEBUG", levels["lockz status changed"])
+	require.Equal(t, "DEBUG", levels["lockz session created"])
+	require.Equal(t, "DEBUG", levels["lockz lock acquired"])
+	require.Equal(t, "DEBUG", levels["lockz lock renewed"])
+	require.Equal(t, "DEBUG", levels["lockz lock released"])
+	require.Equal(t, "DEBUG", levels["lockz session destroyed"])
+	require.Equal(t, "INFO", levels["lockz lock broken"])
+	require.Equal(t, "WARN", levels["lockz lock lost"])
+}
@@ -0,0 +1,389 @@
+package lockz
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("memory", NewMemoryBackend)
+}
+
+// Lockers created with the same IpAddressPort share the same in-memory store,
+// just like lockers connecting to the same Consul.
+// (同一个地址，共用同一份数据)
+var (
+	memoryStoresMutex sync.Mutex
+	memoryStores      = make(map[string]*memoryBackend)
+)
+
+// memoryBackend is the Backend kept in the process, it emulates the sessions and the KV store of Consul.
+type memoryBackend struct {
+	mutex    sync.Mutex
+	index    uint64                    // The index increased by every change, like the Raft index of Consul
+	sessions map[string]*memorySession // The alive sessions
+	pairs    map[string]*KVPair        // The stored key-value pairs
+	indexes  map[string]uint64         // The index of the last change of each key, deletion included
+	delays   map[string]time.Time      // The keys which cannot be acquired until the lock delay passes
+	changed  chan struct{}             // Closed on every change to wake up the watchers
+}
+
+// memorySession is a session with its expiry timer.
+type memorySession struct {
+	entry  SessionEntry
+	expiry time.Time
+	timer  *time.Timer
+}
+
+// NewMemoryBackend returns the in-memory store named by IpAddressPort, creating it on first use.
+func NewMemoryBackend(opts BasicOptions) (backend Backend, err error) {
+	memoryStoresMutex.Lock()
+	defer memoryStoresMutex.Unlock()
+
+	store, ok := memoryStores[opts.IpAddressPort]
+	if !ok {
+		store = &memoryBackend{
+			sessions: make(map[string]*memorySession),
+			pairs:    make(map[string]*KVPair),
+			indexes:  make(map[string]uint64),
+			delays:   make(map[string]time.Time),
+			changed:  make(chan struct{}),
+		}
+		memoryStores[opts.IpAddressPort] = store
+	}
+
+	backend = store
+	return
+}
+
+// CreateSession creates a session, which is invalidated if it is not renewed within its TTL.
+func (b *memoryBackend) CreateSession(ctx context.Context, entry SessionEntry) (sessionID string, err error) {
+	sessionID, err = newSessionID()
+	if err != nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	session := &memorySession{entry: entry}
+	if entry.TTL > 0 {
+		session.expiry = time.Now().Add(entry.TTL)
+		session.timer = time.AfterFunc(entry.TTL, func() {
+			b.expireSession(sessionID)
+		})
+	}
+	b.sessions[sessionID] = session
+	return
+}
+
+// RenewSession pushes the expiry of the session one TTL later.
+func (b *memoryBackend) RenewSession(ctx context.Context, sessionID string) (err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	session, ok := b.sessions[sessionID]
+	if !ok {
+		err = ERROR_SESSION_EXPIRED
+		return
+	}
+
+	if session.timer != nil {
+		session.expiry = time.Now().Add(session.entry.TTL)
+		session.timer.Reset(session.entry.TTL)
+	}
+	return
+}
+
+// DestroySession invalidates the session.
+func (b *memoryBackend) DestroySession(ctx context.Context, sessionID string) (err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.invalidateSession(sessionID)
+	return
+}
+
+// SessionInfo describes the alive session, the sessions of the store belong to no node.
+func (b *memoryBackend) SessionInfo(ctx context.Context, sessionID string) (info *SessionInfo, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	session, ok := b.sessions[sessionID]
+	if !ok {
+		return
+	}
+	info = &SessionInfo{
+		ID:        sessionID,
+		Name:      session.entry.Name,
+		Behavior:  session.entry.Behavior,
+		TTL:       session.entry.TTL,
+		LockDelay: session.entry.LockDelay,
+	}
+	if session.timer != nil {
+		info.ExpiresIn = time.Until(session.expiry)
+	}
+	return
+}
+
+// Acquire writes the key-value pair if the key is free or already held by the same session.
+func (b *memoryBackend) Acquire(ctx context.Context, pair *KVPair) (acquired bool, err error) {
+	return b.AcquireAll(ctx, []*KVPair{pair})
+}
+
+// AcquireAll writes all the key-value pairs if every key is free or already held by the same session, or none of them.
+func (b *memoryBackend) AcquireAll(ctx context.Context, pairs []*KVPair) (acquired bool, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// Only an alive session can acquire a key
+	for _, pair := range pairs {
+		if _, ok := b.sessions[pair.Session]; !ok {
+			err = ERROR_SESSION_EXPIRED
+			return
+		}
+	}
+
+	// Check every key before writing any of them
+	for _, pair := range pairs {
+		if !b.acquirable(pair) {
+			return
+		}
+	}
+
+	for _, pair := range pairs {
+		stored, ok := b.pairs[pair.Key]
+		if !ok {
+			stored = &KVPair{Key: pair.Key, CreateIndex: b.index + 1}
+			b.pairs[pair.Key] = stored
+		}
+		if stored.Session != pair.Session {
+			// Acquiring again by the holder only updates the value
+			stored.Session = pair.Session
+			stored.LockIndex++
+		}
+		stored.Value = pair.Value
+		b.touch(stored)
+	}
+	acquired = true
+	return
+}
+
+// Get returns a copy of the key-value pair.
+func (b *memoryBackend) Get(ctx context.Context, key string) (pair *KVPair, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	pair = copyPair(b.pairs[key])
+	return
+}
+
+// Put writes the value without changing which session holds the key.
+func (b *memoryBackend) Put(ctx context.Context, pair *KVPair) (err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	stored, ok := b.pairs[pair.Key]
+	if !ok {
+		stored = &KVPair{Key: pair.Key, CreateIndex: b.index + 1}
+		b.pairs[pair.Key] = stored
+	}
+	stored.Value = pair.Value
+	b.touch(stored)
+	return
+}
+
+// Delete deletes the key.
+func (b *memoryBackend) Delete(ctx context.Context, key string) (err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.deleteKey(key)
+	return
+}
+
+// DeleteCAS deletes the key only if its modify index is still the one of the pair.
+func (b *memoryBackend) DeleteCAS(ctx context.Context, pair *KVPair) (deleted bool, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	stored, ok := b.pairs[pair.Key]
+	if !ok || stored.ModifyIndex != pair.ModifyIndex {
+		return
+	}
+	b.deleteKey(pair.Key)
+	deleted = true
+	return
+}
+
+// PutCAS writes the value only if the modify index of the key is still the one of the pair,
+// a zero modify index writes it only if the key does not exist.
+func (b *memoryBackend) PutCAS(ctx context.Context, pair *KVPair) (written bool, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	stored, ok := b.pairs[pair.Key]
+	if ok != (pair.ModifyIndex != 0) || (ok && stored.ModifyIndex != pair.ModifyIndex) {
+		return
+	}
+	if !ok {
+		stored = &KVPair{Key: pair.Key, CreateIndex: b.index + 1}
+		b.pairs[pair.Key] = stored
+	}
+	stored.Value = pair.Value
+	b.touch(stored)
+	written = true
+	return
+}
+
+// List returns copies of the key-value pairs under the prefix, sorted by key.
+func (b *memoryBackend) List(ctx context.Context, prefix string) (pairs []*KVPair, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for key, stored := range b.pairs {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, copyPair(stored))
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	return
+}
+
+// Watch blocks until the key changes after waitIndex, a zero waitIndex returns immediately.
+func (b *memoryBackend) Watch(ctx context.Context, key string, waitIndex uint64) (pair *KVPair, lastIndex uint64, err error) {
+	for {
+		b.mutex.Lock()
+		lastIndex = b.indexes[key]
+		if lastIndex == 0 {
+			// Never changed, answer with the current index like Consul does
+			lastIndex = b.index
+		}
+		if waitIndex == 0 || lastIndex > waitIndex {
+			pair = copyPair(b.pairs[key])
+			b.mutex.Unlock()
+			return
+		}
+		changed := b.changed
+		b.mutex.Unlock()
+
+		// Wait for the next change or the end of the context
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// acquirable tells whether the session of the pair can acquire the key. The caller must hold the mutex.
+func (b *memoryBackend) acquirable(pair *KVPair) bool {
+	stored, ok := b.pairs[pair.Key]
+	switch {
+	case ok && stored.Session == pair.Session:
+		// Held by the same session
+		return true
+	case ok && stored.Session != "":
+		// Held by another session
+		return false
+	default:
+		// Not in the lock delay of the previous holder
+		return !time.Now().Before(b.delays[pair.Key])
+	}
+}
+
+// expireSession invalidates the session when its timer fires and it has not been renewed meanwhile.
+func (b *memoryBackend) expireSession(sessionID string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	session, ok := b.sessions[sessionID]
+	if !ok || time.Now().Before(session.expiry) {
+		return
+	}
+	b.invalidateSession(sessionID)
+}
+
+// invalidateSession removes the session and deletes or releases its keys according to its behavior.
+// The caller must hold the mutex.
+func (b *memoryBackend) invalidateSession(sessionID string) {
+	session, ok := b.sessions[sessionID]
+	if !ok {
+		return
+	}
+	if session.timer != nil {
+		session.timer.Stop()
+	}
+	delete(b.sessions, sessionID)
+
+	for key, stored := range b.pairs {
+		if stored.Session != sessionID {
+			continue
+		}
+		// Keep others from grabbing the lock during the lock delay
+		if session.entry.LockDelay > 0 {
+			b.delays[key] = time.Now().Add(session.entry.LockDelay)
+		}
+		if session.entry.Behavior == "delete" {
+			b.deleteKey(key)
+			continue
+		}
+		stored.Session = ""
+		b.touch(stored)
+	}
+}
+
+// deleteKey deletes the key and records the change. The caller must hold the mutex.
+func (b *memoryBackend) deleteKey(key string) {
+	if _, ok := b.pairs[key]; !ok {
+		return
+	}
+	delete(b.pairs, key)
+	b.index++
+	b.indexes[key] = b.index
+	b.notify()
+}
+
+// touch records the change of the key-value pair. The caller must hold the mutex.
+func (b *memoryBackend) touch(stored *KVPair) {
+	b.index++
+	stored.ModifyIndex = b.index
+	b.indexes[stored.Key] = b.index
+	b.notify()
+}
+
+// notify wakes up all watchers. The caller must hold the mutex.
+func (b *memoryBackend) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// copyPair copies the key-value pair, so that callers cannot modify the store.
+func copyPair(stored *KVPair) (pair *KVPair) {
+	if stored == nil {
+		return
+	}
+	copied := *stored
+	copied.Value = append([]byte(nil), stored.Value...)
+	pair = &copied
+	return
+}
+
+// newSessionID generates a random session ID in the UUID format used by Consul.
+func newSessionID() (sessionID string, err error) {
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	sessionID = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	return
+}
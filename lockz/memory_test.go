@@ -0,0 +1,164 @@
+package lockz
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newTestMemoryBackend creates a memory store of its own for each test.
+func newTestMemoryBackend(t *testing.T) Backend {
+	backend, err := NewMemoryBackend(BasicOptions{IpAddressPort: t.Name()})
+	require.NoError(t, err)
+	return backend
+}
+
+// Test_Check_MemoryAcquire confirms that only one session can hold a key at a time.
+func Test_Check_MemoryAcquire(t *testing.T) {
+	backend := newTestMemoryBackend(t)
+	ctx := context.Background()
+
+	// Create two sessions
+	session0, err := backend.CreateSession(ctx, SessionEntry{Behavior: "delete", TTL: 10 * time.Second})
+	require.NoError(t, err)
+	session1, err := backend.CreateSession(ctx, SessionEntry{Behavior: "delete", TTL: 10 * time.Second})
+	require.NoError(t, err)
+	require.NotEqual(t, session0, session1)
+
+	// session0 acquires the key
+	acquired, err := backend.Acquire(ctx, &KVPair{Key: "memory_acquire", Value: []byte("0"), Session: session0})
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// session1 cannot acquire the key held by session0
+	acquired, err = backend.Acquire(ctx, &KVPair{Key: "memory_acquire", Value: []byte("1"), Session: session1})
+	require.NoError(t, err)
+	require.False(t, acquired)
+
+	// session0 can acquire again, which only updates the value
+	acquired, err = backend.Acquire(ctx, &KVPair{Key: "memory_acquire", Value: []byte("00"), Session: session0})
+	require.NoError(t, err)
+	require.True(t, acquired)
+	pair, err := backend.Get(ctx, "memory_acquire")
+	require.NoError(t, err)
+	require.Equal(t, []byte("00"), pair.Value)
+	require.Equal(t, session0, pair.Session)
+	require.Equal(t, uint64(1), pair.LockIndex)
+
+	// Put keeps the holder
+	err = backend.Put(ctx, &KVPair{Key: "memory_acquire", Value: []byte("000")})
+	require.NoError(t, err)
+	pair, err = backend.Get(ctx, "memory_acquire")
+	require.NoError(t, err)
+	require.Equal(t, session0, pair.Session)
+
+	// After session0 is destroyed, the key is deleted and session1 can acquire it
+	err = backend.DestroySession(ctx, session0)
+	require.NoError(t, err)
+	pair, err = backend.Get(ctx, "memory_acquire")
+	require.NoError(t, err)
+	require.Nil(t, pair)
+	acquired, err = backend.Acquire(ctx, &KVPair{Key: "memory_acquire", Value: []byte("1"), Session: session1})
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// A destroyed session cannot acquire or renew anything
+	_, err = backend.Acquire(ctx, &KVPair{Key: "memory_acquire_other", Session: session0})
+	require.Equal(t, ERROR_SESSION_EXPIRED, err)
+	err = backend.RenewSession(ctx, session0)
+	require.Equal(t, ERROR_SESSION_EXPIRED, err)
+}
+
+// Test_Check_MemoryTTL confirms that a session expires without renewal, and renewal keeps it alive.
+func Test_Check_MemoryTTL(t *testing.T) {
+	backend := newTestMemoryBackend(t)
+	ctx := context.Background()
+
+	// Create a session with a short TTL, the released key is kept
+	sessionID, err := backend.CreateSession(ctx, SessionEntry{Behavior: "release", TTL: 200 * time.Millisecond})
+	require.NoError(t, err)
+	acquired, err := backend.Acquire(ctx, &KVPair{Key: "memory_ttl", Session: sessionID})
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// Renew twice within the TTL, the session stays alive
+	for i := 0; i < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+		err = backend.RenewSession(ctx, sessionID)
+		require.NoError(t, err)
+	}
+	pair, err := backend.Get(ctx, "memory_ttl")
+	require.NoError(t, err)
+	require.Equal(t, sessionID, pair.Session)
+
+	// Stop renewing, the session expires and the key is released
+	time.Sleep(400 * time.Millisecond)
+	err = backend.RenewSession(ctx, sessionID)
+	require.Equal(t, ERROR_SESSION_EXPIRED, err)
+	pair, err = backend.Get(ctx, "memory_ttl")
+	require.NoError(t, err)
+	require.NotNil(t, pair)
+	require.Empty(t, pair.Session)
+}
+
+// Test_Check_MemoryLockDelay confirms that nobody can acquire a key during the lock delay of an invalidated session.
+func Test_Check_MemoryLockDelay(t *testing.T) {
+	backend := newTestMemoryBackend(t)
+	ctx := context.Background()
+
+	// session0 holds the key with a lock delay
+	session0, err := backend.CreateSession(ctx, SessionEntry{Behavior: "delete", TTL: 10 * time.Second, LockDelay: 300 * time.Millisecond})
+	require.NoError(t, err)
+	acquired, err := backend.Acquire(ctx, &KVPair{Key: "memory_lock_delay", Session: session0})
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// Invalidate session0
+	err = backend.DestroySession(ctx, session0)
+	require.NoError(t, err)
+
+	// session1 has to wait for the lock delay
+	session1, err := backend.CreateSession(ctx, SessionEntry{Behavior: "delete", TTL: 10 * time.Second})
+	require.NoError(t, err)
+	acquired, err = backend.Acquire(ctx, &KVPair{Key: "memory_lock_delay", Session: session1})
+	require.NoError(t, err)
+	require.False(t, acquired)
+
+	time.Sleep(400 * time.Millisecond)
+	acquired, err = backend.Acquire(ctx, &KVPair{Key: "memory_lock_delay", Session: session1})
+	require.NoError(t, err)
+	require.True(t, acquired)
+}
+
+// Test_Check_MemoryWatch confirms that Watch blocks until the key changes after the wait index.
+func Test_Check_MemoryWatch(t *testing.T) {
+	backend := newTestMemoryBackend(t)
+	ctx := context.Background()
+
+	// A zero wait index returns immediately
+	err := backend.Put(ctx, &KVPair{Key: "memory_watch", Value: []byte("0")})
+	require.NoError(t, err)
+	pair, lastIndex, err := backend.Watch(ctx, "memory_watch", 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("0"), pair.Value)
+
+	// Change the key a little later
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = backend.Put(ctx, &KVPair{Key: "memory_watch_other", Value: []byte("x")})
+		_ = backend.Delete(ctx, "memory_watch")
+	}()
+
+	// Watch wakes up by the deletion only, not by the other key
+	pair, nextIndex, err := backend.Watch(ctx, "memory_watch", lastIndex)
+	require.NoError(t, err)
+	require.Nil(t, pair)
+	require.Greater(t, nextIndex, lastIndex)
+
+	// Watch gives up when the context is done
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, _, err = backend.Watch(timeout, "memory_watch", nextIndex)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
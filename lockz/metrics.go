@@ -0,0 +1,69 @@
+package lockz
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MetricsSink receives the measurements of the lock operations, so that the contention can be seen in production.
+// Set it with BasicOptions.Metrics, the lockz/promz package exports them to Prometheus.
+// The methods are called on the hot path, they must be quick and safe for concurrent use.
+// (看得见抢锁)
+type MetricsSink interface {
+	// ObserveWait records the time spent in BlockOnReleased waiting for the key to be released.
+	ObserveWait(key string, waited time.Duration)
+	// ObserveAcquire records the time spent in one attempt to acquire the key, err is nil on success.
+	ObserveAcquire(key string, took time.Duration, err error)
+	// ObserveRenew records one renewal by Extend or Incr, err is nil on success.
+	ObserveRenew(key string, err error)
+	// LockHeld records that the key is held by a locker from now on.
+	LockHeld(key string)
+	// LockReleased records that the key held for the duration is no longer held, released or lost.
+	LockReleased(key string, held time.Duration)
+}
+
+// errorLabels names the errors of this package for the metrics.
+var errorLabels = map[error]string{
+	ERROR_OCCUPY_BY_OTHER:    "occupy_by_other",
+	ERROR_CANNOT_EXTEND:      "cannot_extend",
+	ERROR_LOCK_RELEASED:      "lock_released",
+	ERROR_SESSION_EXPIRED:    "session_expired",
+	ERROR_ALREADY_HELD:       "already_held",
+	ERROR_NOT_HELD:           "not_held",
+	ERROR_LOCK_TIMEOUT:       "lock_timeout",
+	ERROR_NO_AUTH_DEL:        "no_auth_del",
+	ERROR_CANNOT_TXN:         "cannot_txn",
+	context.Canceled:         "canceled",
+	context.DeadlineExceeded: "deadline_exceeded",
+}
+
+// ErrorLabel names the error for the label of a metric, "ok" for nil and "other" for the unknown errors.
+func ErrorLabel(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	for known, label := range errorLabels {
+		if errors.Is(err, known) {
+			return label
+		}
+	}
+	return "other"
+}
+
+// metrics returns the sink of the options, or one dropping everything.
+func (opts BasicOptions) metrics() MetricsSink {
+	if opts.Metrics == nil {
+		return nopMetrics{}
+	}
+	return opts.Metrics
+}
+
+// nopMetrics drops all the measurements.
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveWait(string, time.Duration)           {}
+func (nopMetrics) ObserveAcquire(string, time.Duration, error) {}
+func (nopMetrics) ObserveRenew(string, error)                  {}
+func (nopMetrics) LockHeld(string)                             {}
+func (nopMetrics) LockReleased(string, time.Duration)          {}
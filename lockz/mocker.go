@@ -13,4 +13,8 @@ const (
 	TestConsulIPPort string = "127.0.0.1:8500"
 )
 
-func SetupMock() {}
+// The mock driver is served by the in-memory backend,
+// so that a locker can run without Consul.
+func init() {
+	Register("mock", NewMemoryBackend)
+}
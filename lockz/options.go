@@ -1,6 +1,8 @@
 package lockz
 
 import (
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
@@ -56,12 +58,20 @@ func NewLockerOptions(funcs ...SetOptsFunc) LockerOptions {
 
 // BasicOptions is the most commonly used configuration values.
 type BasicOptions struct {
-	Driver        string        // Can choose between consul and mock as the driver type.
-	IpAddressPort string        // The address of the lock service, such as a Consul address.
-	SessionTTL    time.Duration // The lifetime of a session in the lock service.
-	ExtendPeriod  time.Duration // The period to extend a session before it expires.
-	LockDelay     time.Duration // Allow temporary interruption time when locking on consul.
-	ExtendLimit   int           // The maximum number of times a lock may be extended.
+	Driver         string               // Can choose between consul and mock as the driver type.
+	IpAddressPort  string               // The address of the lock service, such as a Consul address.
+	SessionTTL     time.Duration        // The lifetime of a session in the lock service.
+	ExtendPeriod   time.Duration        // The period to extend a session before it expires.
+	LockDelay      time.Duration        // Allow temporary interruption time when locking on consul.
+	ExtendLimit    int                  // The maximum number of times a lock may be extended.
+	AutoExtend     bool                 // Start extending on a successful Lock every ExtendPeriod, until the lock is released.
+	Fair           bool                 // Lock in the order of arrival, waiting in a queue under "<key>/queue/" instead of racing. Not on redis.
+	OwnerID        string               // Names the owner of the locks, which re-enters them by locking again through the same locker. Nobody re-enters if empty.
+	Service        string               // The name of the service holding the locks, written into OwnerInfo.
+	Labels         map[string]string    // Free-form labels of the holder, such as the request ID, written into OwnerInfo.
+	Metrics        MetricsSink          // Receives the measurements of the lock operations, nothing is measured if nil.
+	TracerProvider trace.TracerProvider // Emits the spans of the lock operations, nothing is traced if nil.
+	Logger         *slog.Logger         // Logs the lifecycle and the failures of the locks, nothing is logged if nil.
 }
 
 // MockOptions that are only needed for mocking
@@ -81,6 +91,15 @@ func CheckBasicOpts(opts BasicOptions) (err error) {
 	if err != nil {
 		return
 	}
+
+	// ignore the ExtendLimit option
+
+	return checkTimingOpts(opts)
+}
+
+// checkTimingOpts validates the durations of the options, and that the automatic renewal has a period.
+// NewLocker checks them as well, while the address is left to the driver.
+func checkTimingOpts(opts BasicOptions) (err error) {
 	// Check if SessionTTL option is valid and not negative
 	err = CheckDurationFormat(opts.SessionTTL)
 	if err == ERROR_NEGATIVE_TIME_DURATION {
@@ -109,7 +128,11 @@ func CheckBasicOpts(opts BasicOptions) (err error) {
 		return
 	}
 
-	// ignore the ExtendLimit option
+	// The automatic renewal needs a period
+	if opts.AutoExtend && opts.ExtendPeriod == 0 {
+		err = ERROR_EXTENDED_PERIOD_FORMAT
+		return
+	}
 
 	return
 }
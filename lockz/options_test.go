@@ -68,6 +68,17 @@ func Test_Check_CheckOpts(t *testing.T) {
 			},
 			err: ERROR_LOCK_DELAY_FORMAT,
 		},
+		{
+			description: "AutoExtend without ExtendPeriod",
+			opts: BasicOptions{
+				IpAddressPort: "127.0.0.1:8080",
+				SessionTTL:    10,
+				LockDelay:     1,
+				ExtendLimit:   100,
+				AutoExtend:    true,
+			},
+			err: ERROR_EXTENDED_PERIOD_FORMAT,
+		},
 		{
 			description: "ExtendLimit is ignored",
 			opts: BasicOptions{
@@ -0,0 +1,96 @@
+// Package promz exports the measurements of the lock operations to Prometheus, set the sink with BasicOptions.Metrics.
+//
+// The metrics are not labeled by the lock key, which would grow without limit, the results are labeled by ErrorLabel instead.
+package promz
+
+import (
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Sink is the lockz.MetricsSink exporting the measurements to Prometheus.
+type Sink struct {
+	wait     prometheus.Histogram   // The time spent in BlockOnReleased
+	acquire  prometheus.Histogram   // The time spent in one attempt to acquire a lock
+	acquires *prometheus.CounterVec // The attempts to acquire a lock by result
+	held     prometheus.Gauge       // The locks held at the moment
+	renewals *prometheus.CounterVec // The renewals by result
+	hold     prometheus.Histogram   // How long the locks were held
+}
+
+// NewSink creates the metrics under the "lockz" namespace and registers them with the registerer,
+// such as prometheus.DefaultRegisterer.
+func NewSink(registerer prometheus.Registerer) (sink *Sink, err error) {
+	sink = &Sink{
+		wait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "lockz",
+			Name:      "wait_seconds",
+			Help:      "Time spent waiting for a lock to be released by its holder.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}),
+		acquire: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "lockz",
+			Name:      "acquire_seconds",
+			Help:      "Time spent in one attempt to acquire a lock.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		acquires: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lockz",
+			Name:      "acquire_total",
+			Help:      "Attempts to acquire a lock by result, occupy_by_other counts the contention.",
+		}, []string{"result"}),
+		held: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "lockz",
+			Name:      "held_locks",
+			Help:      "Locks held at the moment.",
+		}),
+		renewals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lockz",
+			Name:      "renew_total",
+			Help:      "Renewals of the held locks by result.",
+		}, []string{"result"}),
+		hold: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "lockz",
+			Name:      "hold_seconds",
+			Help:      "How long the locks were held until released or lost.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}),
+	}
+
+	for _, collector := range []prometheus.Collector{sink.wait, sink.acquire, sink.acquires, sink.held, sink.renewals, sink.hold} {
+		err = registerer.Register(collector)
+		if err != nil {
+			sink = nil
+			return
+		}
+	}
+	return
+}
+
+// ObserveWait records the time spent waiting for the key to be released.
+func (sink *Sink) ObserveWait(key string, waited time.Duration) {
+	sink.wait.Observe(waited.Seconds())
+}
+
+// ObserveAcquire records one attempt to acquire the key.
+func (sink *Sink) ObserveAcquire(key string, took time.Duration, err error) {
+	sink.acquire.Observe(took.Seconds())
+	sink.acquires.WithLabelValues(lockz.ErrorLabel(err)).Inc()
+}
+
+// ObserveRenew records one renewal.
+func (sink *Sink) ObserveRenew(key string, err error) {
+	sink.renewals.WithLabelValues(lockz.ErrorLabel(err)).Inc()
+}
+
+// LockHeld counts the key as held.
+func (sink *Sink) LockHeld(key string) {
+	sink.held.Inc()
+}
+
+// LockReleased counts the key as no longer held, and records how long it was held.
+func (sink *Sink) LockReleased(key string, held time.Duration) {
+	sink.held.Dec()
+	sink.hold.Observe(held.Seconds())
+}
@@ -0,0 +1,50 @@
+package promz
+
+import (
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_Sink confirms that the lock operations show up in the metrics.
+func Test_Check_Sink(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	sink, err := NewSink(registry)
+	require.NoError(t, err)
+
+	// Create two lockers measured by the sink
+	opts := lockz.BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+		Metrics:     sink,
+	}
+	locker0, err := lockz.NewLocker(opts)
+	require.NoError(t, err)
+	locker1, err := lockz.NewLocker(opts)
+	require.NoError(t, err)
+
+	// One holds the lock and renews it, the other one runs into it
+	handle, err := locker0.Lock("prometheus_test")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, testutil.ToFloat64(sink.held))
+	require.NoError(t, handle.Incr())
+	_, err = locker1.TryLockOnce("prometheus_test")
+	require.Equal(t, lockz.ERROR_OCCUPY_BY_OTHER, err)
+	require.Equal(t, 1.0, testutil.ToFloat64(sink.acquires.WithLabelValues("ok")))
+	require.Equal(t, 1.0, testutil.ToFloat64(sink.acquires.WithLabelValues("occupy_by_other")))
+	require.Equal(t, 1.0, testutil.ToFloat64(sink.renewals.WithLabelValues("ok")))
+
+	// The release is counted once
+	require.NoError(t, handle.Release())
+	require.Equal(t, lockz.ERROR_LOCK_RELEASED, handle.Release())
+	require.Equal(t, 0.0, testutil.ToFloat64(sink.held))
+	require.Equal(t, 1, testutil.CollectAndCount(sink.hold))
+
+	// The same metrics cannot be registered twice
+	_, err = NewSink(registry)
+	require.Error(t, err)
+}
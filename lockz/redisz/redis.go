@@ -0,0 +1,650 @@
+// Package redisz registers the "redis" driver of lockz.
+// Redis has no sessions, so a session of lockz is a key of its own expiring with the session TTL,
+// along with the set of the keys it holds, and any process can renew or destroy it.
+// Each key acquired by the session carries the session TTL as its own expiry.
+// The session holding a key is read from the LockDetail JSON stored in it.
+//
+//	import _ "github.com/panhongrainbow/consensusLockz/lockz/redisz"
+//
+// The Redlock algorithm over independent nodes is registered under a driver name of your choice.
+//
+//	lockz.Register("redlock", redisz.NewRedlockFactory("10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"))
+//
+// The nodes of Redlock count the acquisitions independently, so they issue no fencing tokens, and ValidateToken refuses them.
+// The keys are counted one by one, so the fair mode cannot line the contenders up and is refused.
+package redisz
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	"github.com/redis/go-redis/v9"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_ADDRESS       = "127.0.0.1:6379"
+	DEFAULT_POLL_INTERVAL = 100 * time.Millisecond // Redis has no blocking query on a key, Watch polls it instead
+	CLOCK_DRIFT_FACTOR    = 0.01                   // The clock drift allowed by Redlock, in proportion to the TTL
+)
+
+const (
+	ERROR_NO_QUORUM = lockz.Error("Distributed lock error because the majority of the redis nodes did not agree")
+)
+
+// The scripts compare the session ID inside the LockDetail JSON before touching the key.
+// (先比对 SessionID，再动手)
+var (
+	// acquireScript sets the key with SET NX PX and counts the acquisition as the fencing token,
+	// or acquires the key again for the session holding it, which only updates the value and the expiry.
+	// KEYS holds the key, its fencing key, the session key and the set of the keys held by the session.
+	acquireScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[3]) == 0 then
+	return 0
+end
+if redis.call("SET", KEYS[1], ARGV[2], "NX", "PX", ARGV[3]) then
+	redis.call("INCR", KEYS[2])
+	redis.call("SADD", KEYS[4], KEYS[1])
+	redis.call("PEXPIRE", KEYS[4], ARGV[3])
+	return 1
+end
+local value = redis.call("GET", KEYS[1])
+if value then
+	local ok, detail = pcall(cjson.decode, value)
+	if ok and detail.session_id == ARGV[1] then
+		redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+		return 1
+	end
+end
+return 0`)
+	// acquireAllScript sets all the keys, only if none of them exists, and counts the acquisitions.
+	// KEYS holds the keys followed by their fencing keys, the session key and the set of the keys held by the session,
+	// ARGV holds the TTL followed by the values.
+	acquireAllScript = redis.NewScript(`
+local n = (#KEYS - 2) / 2
+if redis.call("EXISTS", KEYS[#KEYS - 1]) == 0 then
+	return 0
+end
+for i = 1, n do
+	if redis.call("EXISTS", KEYS[i]) == 1 then
+		return 0
+	end
+end
+for i = 1, n do
+	redis.call("SET", KEYS[i], ARGV[i + 1], "PX", ARGV[1])
+	redis.call("INCR", KEYS[n + i])
+	redis.call("SADD", KEYS[#KEYS], KEYS[i])
+end
+redis.call("PEXPIRE", KEYS[#KEYS], ARGV[1])
+return 1`)
+	// renewSessionScript pushes the expiry of the session key and of its set of keys, if the session is still alive.
+	renewSessionScript = redis.NewScript(`
+if redis.call("PEXPIRE", KEYS[1], ARGV[1]) == 1 then
+	redis.call("PEXPIRE", KEYS[2], ARGV[1])
+	return 1
+end
+return 0`)
+	// extendScript pushes the expiry of the key if the session still holds it.
+	extendScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value then
+	local ok, detail = pcall(cjson.decode, value)
+	if ok and detail.session_id == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+end
+return 0`)
+	// putScript writes the value and keeps the expiry if the session still holds the key.
+	putScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value then
+	local ok, detail = pcall(cjson.decode, value)
+	if ok and detail.session_id == ARGV[1] then
+		redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+		return 1
+	end
+end
+return 0`)
+	// releaseScript deletes the key if the session still holds it.
+	releaseScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value then
+	local ok, detail = pcall(cjson.decode, value)
+	if ok and detail.session_id == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+end
+return 0`)
+	// deleteCASScript deletes the key if its value is still the same.
+	deleteCASScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+func init() {
+	lockz.Register("redis", NewRedisBackend)
+}
+
+// redisBackend is the lockz.Backend on top of one or more independent Redis nodes.
+type redisBackend struct {
+	nodes  []*redis.Client
+	quorum int // The number of nodes that must agree
+}
+
+// NewRedisBackend connects to a single Redis node, which is DEFAULT_ADDRESS unless IpAddressPort is set.
+func NewRedisBackend(opts lockz.BasicOptions) (backend lockz.Backend, err error) {
+	address := DEFAULT_ADDRESS
+	if opts.IpAddressPort != "" {
+		address = opts.IpAddressPort
+	}
+	backend = newRedisBackend([]string{address})
+	return
+}
+
+// NewRedlockFactory returns a factory connecting to all the independent Redis nodes,
+// an operation succeeds only when the majority of them agree (Redlock).
+func NewRedlockFactory(addresses ...string) lockz.BackendFactory {
+	return func(opts lockz.BasicOptions) (backend lockz.Backend, err error) {
+		for _, address := range addresses {
+			err = lockz.CheckIpAddressPort(address)
+			if err != nil {
+				return
+			}
+		}
+		backend = newRedisBackend(addresses)
+		return
+	}
+}
+
+// newRedisBackend creates the clients of the nodes.
+func newRedisBackend(addresses []string) *redisBackend {
+	b := &redisBackend{
+		quorum: len(addresses)/2 + 1,
+	}
+	for _, address := range addresses {
+		b.nodes = append(b.nodes, redis.NewClient(&redis.Options{Addr: address}))
+	}
+	return b
+}
+
+// Close closes the clients of the nodes, which is called by the locker no longer using them.
+func (b *redisBackend) Close() (err error) {
+	for _, node := range b.nodes {
+		closeErr := node.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// FencingIndex tells that the acquisitions of a key are counted consistently only on a single node.
+func (b *redisBackend) FencingIndex() bool {
+	return len(b.nodes) == 1
+}
+
+// ArrivalIndex tells that the keys are counted one by one, not in the order of their creation.
+func (b *redisBackend) ArrivalIndex() bool {
+	return false
+}
+
+// CreateSession writes the session key holding the TTL on the majority of the nodes, Redis has no lock delay.
+func (b *redisBackend) CreateSession(ctx context.Context, entry lockz.SessionEntry) (sessionID string, err error) {
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return
+	}
+
+	err = b.quorumDo(func(node *redis.Client) error {
+		return node.Set(ctx, sessionKey(hex.EncodeToString(id)), entry.TTL.Milliseconds(), entry.TTL).Err()
+	})
+	if err != nil {
+		return
+	}
+	sessionID = hex.EncodeToString(id)
+	return
+}
+
+// RenewSession pushes the expiry of the session and of every key it still holds on the majority of the nodes.
+func (b *redisBackend) RenewSession(ctx context.Context, sessionID string) (err error) {
+	ttl, err := b.sessionTTL(ctx, sessionID)
+	if err != nil {
+		return
+	}
+
+	for _, key := range b.heldKeys(ctx, sessionID) {
+		err = b.quorumScript(ctx, extendScript, key, sessionID, ttl.Milliseconds())
+		if err != nil {
+			// The key has been lost, so has the session
+			_ = b.DestroySession(ctx, sessionID)
+			err = lockz.ERROR_SESSION_EXPIRED
+			return
+		}
+	}
+
+	err = b.quorumDo(func(node *redis.Client) error {
+		n, err := renewSessionScript.Run(ctx, node, []string{sessionKey(sessionID), sessionKeysKey(sessionID)}, ttl.Milliseconds()).Int()
+		if err == nil && n != 1 {
+			err = ERROR_NO_QUORUM
+		}
+		return err
+	})
+	if errors.Is(err, ERROR_NO_QUORUM) {
+		err = lockz.ERROR_SESSION_EXPIRED
+	}
+	return
+}
+
+// DestroySession deletes the keys still held by the session and the session itself on every node,
+// which works from any process knowing the session ID, such as BreakLock.
+func (b *redisBackend) DestroySession(ctx context.Context, sessionID string) (err error) {
+	for _, key := range b.heldKeys(ctx, sessionID) {
+		for _, node := range b.nodes {
+			_ = releaseScript.Run(ctx, node, []string{key}, sessionID).Err()
+		}
+	}
+
+	return b.quorumDo(func(node *redis.Client) error {
+		return node.Del(ctx, sessionKey(sessionID), sessionKeysKey(sessionID)).Err()
+	})
+}
+
+// Acquire runs SET NX PX on every node, and holds it only if the majority succeeded within the TTL.
+func (b *redisBackend) Acquire(ctx context.Context, pair *lockz.KVPair) (acquired bool, err error) {
+	ttl, err := b.sessionTTL(ctx, pair.Session)
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+	succeeded, answered := 0, 0
+	keys := []string{pair.Key, fencingKey(pair.Key), sessionKey(pair.Session), sessionKeysKey(pair.Session)}
+	for _, node := range b.nodes {
+		n, nodeErr := acquireScript.Run(ctx, node, keys, pair.Session, pair.Value, ttl.Milliseconds()).Int()
+		ok := n == 1
+		if nodeErr != nil {
+			err = nodeErr
+			continue
+		}
+		answered++
+		if ok {
+			succeeded++
+		}
+	}
+
+	// The lock is valid only if the majority agreed and the TTL has not been used up (Redlock)
+	drift := time.Duration(float64(ttl)*CLOCK_DRIFT_FACTOR) + 2*time.Millisecond
+	if succeeded >= b.quorum && time.Since(start)+drift < ttl {
+		acquired, err = true, nil
+		return
+	}
+
+	// Undo the partial acquisition
+	b.undo(ctx, pair.Session, pair.Key)
+
+	// Losing to another session is not an error, only unreachable nodes are
+	if answered >= b.quorum {
+		err = nil
+	}
+	return
+}
+
+// AcquireAll sets all the keys on every node, only if none of them exists there,
+// and keeps them if the majority agreed in time (Redlock), the same as Acquire.
+// All the pairs must carry the same session.
+func (b *redisBackend) AcquireAll(ctx context.Context, pairs []*lockz.KVPair) (acquired bool, err error) {
+	if len(pairs) == 0 {
+		acquired = true
+		return
+	}
+	sessionID := pairs[0].Session
+	ttl, err := b.sessionTTL(ctx, sessionID)
+	if err != nil {
+		return
+	}
+
+	// The keys followed by their fencing keys and the keys of the session, the TTL followed by the values
+	keys := make([]string, 0, 2*len(pairs)+2)
+	args := []interface{}{ttl.Milliseconds()}
+	for _, pair := range pairs {
+		keys = append(keys, pair.Key)
+		args = append(args, pair.Value)
+	}
+	for _, pair := range pairs {
+		keys = append(keys, fencingKey(pair.Key))
+	}
+	keys = append(keys, sessionKey(sessionID), sessionKeysKey(sessionID))
+
+	start := time.Now()
+	succeeded, answered := 0, 0
+	for _, node := range b.nodes {
+		n, nodeErr := acquireAllScript.Run(ctx, node, keys, args...).Int()
+		if nodeErr != nil {
+			err = nodeErr
+			continue
+		}
+		answered++
+		if n == 1 {
+			succeeded++
+		}
+	}
+
+	// The locks are valid only if the majority agreed and the TTL has not been used up (Redlock)
+	drift := time.Duration(float64(ttl)*CLOCK_DRIFT_FACTOR) + 2*time.Millisecond
+	if succeeded >= b.quorum && time.Since(start)+drift < ttl {
+		acquired, err = true, nil
+		return
+	}
+
+	// Undo the partial acquisition
+	for _, pair := range pairs {
+		b.undo(ctx, sessionID, pair.Key)
+	}
+
+	// Losing to another session is not an error, only unreachable nodes are
+	if answered >= b.quorum {
+		err = nil
+	}
+	return
+}
+
+// Get reads the key from every node and returns the value agreed by the majority.
+func (b *redisBackend) Get(ctx context.Context, key string) (pair *lockz.KVPair, err error) {
+	pair, _, err = b.get(ctx, key)
+	return
+}
+
+// Put writes the value and keeps the expiry, if a session is given, the key must still be held by it.
+func (b *redisBackend) Put(ctx context.Context, pair *lockz.KVPair) (err error) {
+	if pair.Session == "" {
+		return b.quorumDo(func(node *redis.Client) error {
+			return node.Set(ctx, pair.Key, pair.Value, redis.KeepTTL).Err()
+		})
+	}
+
+	err = b.quorumScript(ctx, putScript, pair.Key, pair.Session, pair.Value)
+	if errors.Is(err, ERROR_NO_QUORUM) {
+		err = lockz.ERROR_OCCUPY_BY_OTHER
+	}
+	return
+}
+
+// Delete deletes the key on every node.
+func (b *redisBackend) Delete(ctx context.Context, key string) (err error) {
+	return b.quorumDo(func(node *redis.Client) error {
+		return node.Del(ctx, key).Err()
+	})
+}
+
+// DeleteCAS deletes the key only if its value is still the one of the pair (compare-and-delete).
+func (b *redisBackend) DeleteCAS(ctx context.Context, pair *lockz.KVPair) (deleted bool, err error) {
+	err = b.quorumScript(ctx, deleteCASScript, pair.Key, pair.Value)
+	if errors.Is(err, ERROR_NO_QUORUM) {
+		err = nil
+		return
+	}
+	deleted = err == nil
+	return
+}
+
+// List scans the keys under the prefix on every node, and returns the ones agreed by the majority, sorted by key.
+// The fencing counters and the sessions are not listed.
+func (b *redisBackend) List(ctx context.Context, prefix string) (pairs []*lockz.KVPair, err error) {
+	// Collect the keys from the nodes which answer
+	keys := make(map[string]struct{})
+	answered := 0
+	for _, node := range b.nodes {
+		nodeKeys, nodeErr := b.scan(ctx, node, prefix)
+		if nodeErr != nil {
+			err = nodeErr
+			continue
+		}
+		answered++
+		for _, key := range nodeKeys {
+			if !strings.HasPrefix(key, fencingKey("")) && !strings.HasPrefix(key, sessionKey("")) {
+				keys[key] = struct{}{}
+			}
+		}
+	}
+	if answered < b.quorum {
+		if err == nil {
+			err = ERROR_NO_QUORUM
+		}
+		return
+	}
+	err = nil
+
+	// Read every key by the majority, a key left on a minority of the nodes does not count
+	for key := range keys {
+		var pair *lockz.KVPair
+		pair, _, err = b.get(ctx, key)
+		if errors.Is(err, ERROR_NO_QUORUM) {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if pair != nil {
+			pairs = append(pairs, pair)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	return
+}
+
+// scan lists the keys under the prefix on the node.
+func (b *redisBackend) scan(ctx context.Context, node *redis.Client, prefix string) (keys []string, err error) {
+	iter := node.Scan(ctx, 0, escapePattern(prefix)+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	err = iter.Err()
+	return
+}
+
+// Watch polls the key every DEFAULT_POLL_INTERVAL until its value differs from the one of waitIndex.
+// The index is a hash of the value, so it only tells whether the value changed.
+func (b *redisBackend) Watch(ctx context.Context, key string, waitIndex uint64) (pair *lockz.KVPair, lastIndex uint64, err error) {
+	ticker := time.NewTicker(DEFAULT_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		pair, lastIndex, err = b.get(ctx, key)
+		if err != nil || waitIndex == 0 || lastIndex != waitIndex {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// get reads the key from every node, and returns the value agreed by the majority with its hash as the index.
+// The fencing counter becomes the create index, the largest one among the agreeing nodes is taken.
+// (Redlock has no agreed counter, so the token is only reliable on a single node !)
+func (b *redisBackend) get(ctx context.Context, key string) (pair *lockz.KVPair, index uint64, err error) {
+	// Count the same values, a missing key counts as the empty string
+	votes := make(map[string]int)
+	tokens := make(map[string]uint64)
+	for _, node := range b.nodes {
+		values, nodeErr := node.MGet(ctx, key, fencingKey(key)).Result()
+		if nodeErr != nil {
+			continue
+		}
+		value, _ := values[0].(string)
+		votes[value]++
+
+		counter, _ := values[1].(string)
+		token, _ := strconv.ParseUint(counter, 10, 64)
+		if token > tokens[value] {
+			tokens[value] = token
+		}
+	}
+
+	for value, count := range votes {
+		if count < b.quorum {
+			continue
+		}
+		index = hashIndex(value)
+		if value == "" {
+			return
+		}
+		pair = &lockz.KVPair{
+			Key:         key,
+			Value:       []byte(value),
+			Session:     sessionOf([]byte(value)),
+			CreateIndex: tokens[value],
+		}
+		return
+	}
+
+	err = ERROR_NO_QUORUM
+	return
+}
+
+// quorumScript runs the script on every node, it succeeds if the script returns 1 on the majority.
+func (b *redisBackend) quorumScript(ctx context.Context, script *redis.Script, key string, args ...interface{}) error {
+	return b.quorumDo(func(node *redis.Client) error {
+		n, err := script.Run(ctx, node, []string{key}, args...).Int()
+		if err == nil && n != 1 {
+			err = ERROR_NO_QUORUM
+		}
+		return err
+	})
+}
+
+// quorumDo runs the operation on every node, it succeeds if the operation succeeds on the majority.
+func (b *redisBackend) quorumDo(operation func(node *redis.Client) error) (err error) {
+	succeeded := 0
+	for _, node := range b.nodes {
+		nodeErr := operation(node)
+		if nodeErr != nil {
+			err = nodeErr
+			continue
+		}
+		succeeded++
+	}
+
+	if succeeded >= b.quorum {
+		return nil
+	}
+	if err == nil || errors.Is(err, ERROR_NO_QUORUM) {
+		err = ERROR_NO_QUORUM
+	}
+	return
+}
+
+// sessionTTL reads the TTL of the session agreed by the majority of the nodes,
+// it returns ERROR_SESSION_EXPIRED if the session no longer exists.
+func (b *redisBackend) sessionTTL(ctx context.Context, sessionID string) (ttl time.Duration, err error) {
+	found, answered := 0, 0
+	for _, node := range b.nodes {
+		value, nodeErr := node.Get(ctx, sessionKey(sessionID)).Int64()
+		if nodeErr != nil && !errors.Is(nodeErr, redis.Nil) {
+			err = nodeErr
+			continue
+		}
+		answered++
+		if nodeErr == nil {
+			found++
+			ttl = time.Duration(value) * time.Millisecond
+		}
+	}
+
+	if found >= b.quorum {
+		err = nil
+		return
+	}
+	if answered >= b.quorum {
+		err = lockz.ERROR_SESSION_EXPIRED
+	}
+	return
+}
+
+// heldKeys collects the keys held by the session from every node.
+func (b *redisBackend) heldKeys(ctx context.Context, sessionID string) (keys []string) {
+	seen := make(map[string]struct{})
+	for _, node := range b.nodes {
+		members, err := node.SMembers(ctx, sessionKeysKey(sessionID)).Result()
+		if err != nil {
+			continue
+		}
+		for _, key := range members {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// undo releases the key partially acquired by the session on every node.
+func (b *redisBackend) undo(ctx context.Context, sessionID string, key string) {
+	for _, node := range b.nodes {
+		_ = releaseScript.Run(ctx, node, []string{key}, sessionID).Err()
+		_ = node.SRem(ctx, sessionKeysKey(sessionID), key).Err()
+	}
+}
+
+// fencingKey is the key counting the acquisitions of the lock key.
+func fencingKey(key string) string {
+	return "lockz:fencing:" + key
+}
+
+// sessionKey is the key of the session, holding its TTL in milliseconds.
+func sessionKey(sessionID string) string {
+	return "lockz:session:" + sessionID
+}
+
+// sessionKeysKey is the set of the keys held by the session.
+func sessionKeysKey(sessionID string) string {
+	return sessionKey(sessionID) + ":keys"
+}
+
+// escapePattern escapes the glob characters of the key, so that SCAN matches it literally.
+func escapePattern(key string) string {
+	var builder strings.Builder
+	for _, r := range key {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// sessionOf reads the session ID from the LockDetail JSON.
+func sessionOf(value []byte) string {
+	var detail lockz.LockDetail
+	if json.Unmarshal(value, &detail) != nil {
+		return ""
+	}
+	return detail.SessionID
+}
+
+// hashIndex hashes the value to a non-zero index.
+func hashIndex(value string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(value))
+	return h.Sum64() | 1
+}
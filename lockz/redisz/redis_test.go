@@ -0,0 +1,227 @@
+package redisz
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/panhongrainbow/consensusLockz/lockz"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_RedisLock tests two lockers on a single Redis node, lock and unlock them alternatively, and wait on each other.
+func Test_Check_RedisLock(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	// Create two lockers with the redis driver
+	var locker0, locker1 lockz.Locker
+	var err error
+	locker0, err = lockz.NewLocker(lockz.BasicOptions{
+		Driver:        "redis",
+		IpAddressPort: server.Addr(),
+		SessionTTL:    5 * time.Second,
+	})
+	require.NoError(t, err)
+	locker1, err = lockz.NewLocker(lockz.BasicOptions{
+		Driver:        "redis",
+		IpAddressPort: server.Addr(),
+		SessionTTL:    5 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// Lock and unlock them alternatively
+	for i := 0; i < 3; i++ {
+		var handle *lockz.LockHandle
+		handle, err = locker0.Lock("redis_lock_test")
+		require.NoError(t, err)
+		require.NotNil(t, handle)
+		_, err = locker0.UnLock("redis_lock_test")
+		require.NoError(t, err)
+
+		handle, err = locker1.Lock("redis_lock_test")
+		require.NoError(t, err)
+		require.NotNil(t, handle)
+		_, err = locker1.UnLock("redis_lock_test")
+		require.NoError(t, err)
+
+		// Every acquisition is counted as the fencing token
+		require.Equal(t, uint64(2*i+2), handle.Token)
+	}
+
+	// locker0 holds the lock with the session TTL as the expiry
+	handle, err := locker0.Lock("redis_lock_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+	require.Equal(t, 5*time.Second, server.TTL("redis_lock_test"))
+
+	// locker1 cannot delete the lock of locker0
+	_, err = locker1.UnLock("redis_lock_test")
+	require.Equal(t, lockz.ERROR_NO_AUTH_DEL, err)
+
+	// locker1 waits on it in vain
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err = locker1.LockContext(ctx, "redis_lock_test")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// locker1 is woken up when the key expires
+	server.FastForward(5 * time.Second)
+	handle, err = locker1.Lock("redis_lock_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+}
+
+// Test_Check_RedisIncr confirms that Incr keeps the expiry and renewing the session pushes it.
+func Test_Check_RedisIncr(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	// Acquire the lock
+	locker, err := lockz.NewLocker(lockz.BasicOptions{
+		Driver:        "redis",
+		IpAddressPort: server.Addr(),
+		SessionTTL:    5 * time.Second,
+		ExtendLimit:   20,
+	})
+	require.NoError(t, err)
+	handle, err := locker.Lock("redis_incr_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+
+	// Increment the lock, which keeps the expiry
+	server.FastForward(2 * time.Second)
+	for i := 0; i < 10; i++ {
+		err = locker.Incr("redis_incr_test")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3*time.Second, server.TTL("redis_incr_test"))
+	detail, err := locker.LockStatus("redis_incr_test")
+	require.NoError(t, err)
+	require.Equal(t, 10, detail.Extend)
+
+	// Another session cannot write the held key
+	backend, err := NewRedisBackend(lockz.BasicOptions{IpAddressPort: server.Addr()})
+	require.NoError(t, err)
+	err = backend.Put(context.Background(), &lockz.KVPair{Key: "redis_incr_test", Value: []byte("{}"), Session: "other"})
+	require.Equal(t, lockz.ERROR_OCCUPY_BY_OTHER, err)
+}
+
+// Test_Check_RedisSession confirms that the session lives in Redis, so another process can destroy it.
+func Test_Check_RedisSession(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+	holder, err := NewRedisBackend(lockz.BasicOptions{IpAddressPort: server.Addr()})
+	require.NoError(t, err)
+	operator, err := NewRedisBackend(lockz.BasicOptions{IpAddressPort: server.Addr()})
+	require.NoError(t, err)
+
+	// The holder acquires the key and renews it
+	sessionID, err := holder.CreateSession(ctx, lockz.SessionEntry{TTL: 5 * time.Second})
+	require.NoError(t, err)
+	acquired, err := holder.Acquire(ctx, &lockz.KVPair{Key: "redis_session_test", Value: []byte(`{"session_id":"` + sessionID + `"}`), Session: sessionID})
+	require.NoError(t, err)
+	require.True(t, acquired)
+	server.FastForward(3 * time.Second)
+	require.NoError(t, holder.RenewSession(ctx, sessionID))
+	require.Equal(t, 5*time.Second, server.TTL("redis_session_test"))
+
+	// The operator destroys the session, which releases the key and stops the renewal of the holder
+	require.NoError(t, operator.DestroySession(ctx, sessionID))
+	require.False(t, server.Exists("redis_session_test"))
+	require.Equal(t, lockz.ERROR_SESSION_EXPIRED, holder.RenewSession(ctx, sessionID))
+
+	// The destroyed session cannot acquire anything
+	_, err = holder.Acquire(ctx, &lockz.KVPair{Key: "redis_session_test", Value: []byte(`{}`), Session: sessionID})
+	require.Equal(t, lockz.ERROR_SESSION_EXPIRED, err)
+}
+
+// Test_Check_Redlock confirms that the lock follows the majority of the nodes.
+func Test_Check_Redlock(t *testing.T) {
+	servers := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t), miniredis.RunT(t)}
+	factory := NewRedlockFactory(servers[0].Addr(), servers[1].Addr(), servers[2].Addr())
+	lockz.Register("redlock_test", factory)
+
+	// Create two lockers on the three nodes
+	var locker0, locker1 lockz.Locker
+	var err error
+	locker0, err = lockz.NewLocker(lockz.BasicOptions{Driver: "redlock_test", SessionTTL: 5 * time.Second})
+	require.NoError(t, err)
+	locker1, err = lockz.NewLocker(lockz.BasicOptions{Driver: "redlock_test", SessionTTL: 5 * time.Second})
+	require.NoError(t, err)
+
+	// One node is down, the majority is still there
+	servers[2].Close()
+	handle, err := locker0.Lock("redlock_test")
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+	require.True(t, servers[0].Exists("redlock_test"))
+	require.True(t, servers[1].Exists("redlock_test"))
+
+	// The nodes count the acquisitions independently, so no fencing token is issued
+	require.Zero(t, handle.Token)
+	require.Equal(t, lockz.ERROR_NO_FENCING, locker0.ValidateToken("redlock_test", handle.Token))
+	_, err = locker0.UnLock("redlock_test")
+	require.NoError(t, err)
+
+	// A key left on a minority of the nodes is not a lock
+	servers[0].Set("redlock_test", `{"session_id":"stale"}`)
+	handle, err = locker1.Lock("redlock_test")
+	require.Error(t, err)
+	require.Nil(t, handle)
+
+	// Two nodes are down, nobody can lock
+	servers[0].Del("redlock_test")
+	servers[1].Close()
+	handle, err = locker1.Lock("redlock_test")
+	require.Error(t, err)
+	require.Nil(t, handle)
+}
+
+// Test_Check_RedisList confirms that List returns the keys under the prefix agreed by the majority, without the fencing counters.
+func Test_Check_RedisList(t *testing.T) {
+	servers := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t), miniredis.RunT(t)}
+	backend := newRedisBackend([]string{servers[0].Addr(), servers[1].Addr(), servers[2].Addr()})
+	ctx := context.Background()
+
+	// Two keys on the majority, one on a minority, and one out of the prefix
+	for _, server := range servers[:2] {
+		server.Set("redis_list/b", `{"session_id":"s1"}`)
+		server.Set("redis_list/a*", `{"session_id":"s0"}`)
+		server.Set(fencingKey("redis_list/b"), "3")
+		server.Set("redis_list_other", "{}")
+	}
+	servers[2].Set("redis_list/c", `{"session_id":"stale"}`)
+
+	// Only the agreed keys are listed, sorted by key
+	pairs, err := backend.List(ctx, "redis_list/")
+	require.NoError(t, err)
+	require.Len(t, pairs, 2)
+	require.Equal(t, "redis_list/a*", pairs[0].Key)
+	require.Equal(t, "s0", pairs[0].Session)
+	require.Equal(t, "redis_list/b", pairs[1].Key)
+	require.Equal(t, uint64(3), pairs[1].CreateIndex)
+
+	// The glob characters of the prefix match literally
+	pairs, err = backend.List(ctx, "redis_list/a*")
+	require.NoError(t, err)
+	require.Len(t, pairs, 1)
+
+	// Two nodes are down, nothing can be listed
+	servers[0].Close()
+	servers[1].Close()
+	_, err = backend.List(ctx, "redis_list/")
+	require.Error(t, err)
+}
+
+// Test_Check_RedisFair confirms that the fair mode is refused, redis does not keep the order of arrival.
+func Test_Check_RedisFair(t *testing.T) {
+	server := miniredis.RunT(t)
+	locker, err := lockz.NewLocker(lockz.BasicOptions{
+		Driver:        "redis",
+		IpAddressPort: server.Addr(),
+		SessionTTL:    5 * time.Second,
+		Fair:          true,
+	})
+	require.NoError(t, err)
+	_, err = locker.Lock("redis_fair_test")
+	require.Equal(t, lockz.ERROR_CANNOT_QUEUE, err)
+}
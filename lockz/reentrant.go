@@ -0,0 +1,153 @@
+package lockz
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// A lock is re-entered when its owner locks it again, instead of waiting for itself.
+// The owner is named on purpose, by WithOwner in the context of the call or by BasicOptions.OwnerID for all the calls of a locker,
+// and it is compared with the Owner written in LockDetail. A call without an owner never re-enters,
+// so the goroutines sharing a locker exclude each other unless they are given the same owner.
+// The hold count in LockDetail counts the nested Lock calls, each UnLock takes one back,
+// and the last one releases the lock, while Release on the handle releases it at once.
+// The session stays the same all along, so the lock is never lost in between.
+// Only the locker holding the lock re-enters it, another locker of the same owner waits like anybody else,
+// as it could not renew nor release a session it does not own.
+// (同一个主人，可以重复上锁)
+
+// ownerKey is the key of the owner in the context.
+type ownerKey struct{}
+
+// WithOwner returns a context naming the owner of the locks taken with it, the owner re-enters the locks it holds.
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+// ownerOf returns the owner named by the context, or BasicOptions.OwnerID.
+func ownerOf(ctx context.Context, opts BasicOptions) string {
+	if owner, ok := ctx.Value(ownerKey{}).(string); ok && owner != "" {
+		return owner
+	}
+	return opts.OwnerID
+}
+
+// reenter increments the hold count of the lock held by the owner through this locker.
+// It returns ERROR_ALREADY_HELD if this locker holds the lock for someone else, and ERROR_NOT_HELD if the lock has to be acquired.
+func (locker *Locker) reenter(ctx context.Context, key string, owner string) (handle *LockHandle, err error) {
+	held := locker.held.get(key)
+	if held == nil {
+		err = ERROR_NOT_HELD
+		return
+	}
+
+	// Held by this locker for another owner, or for nobody in particular
+	if owner == "" || held.owner != owner {
+		err = ERROR_ALREADY_HELD
+		return
+	}
+
+	_, err = held.changeHolds(1)
+	if err == ERROR_LOCK_RELEASED || err == ERROR_OCCUPY_BY_OTHER {
+		// Gone meanwhile, clean it up and acquire it again
+		_ = held.releaseContext(ctx)
+		err = ERROR_NOT_HELD
+	}
+	if err == nil {
+		handle = held
+	}
+	return
+}
+
+// leave decrements the hold count of the lock, and releases the lock once nobody holds it.
+// A lock gone meanwhile is released as well, to clean up the session.
+func (handle *LockHandle) leave(ctx context.Context) (err error) {
+	holds, err := handle.changeHolds(-1)
+	if err == ERROR_LOCK_RELEASED || err == ERROR_OCCUPY_BY_OTHER || (err == nil && holds < 1) {
+		// Nobody re-enters a lock without holds, so it is released safely
+		return handle.releaseContext(ctx)
+	}
+	return
+}
+
+// changeHolds adds delta to the hold count of the lock held by the handle, and returns the new count.
+// It returns ERROR_LOCK_RELEASED once the last hold has been taken back.
+func (handle *LockHandle) changeHolds(delta int) (holds int, err error) {
+	handle.detailMutex.Lock()
+	defer handle.detailMutex.Unlock()
+
+	// A released handle is not re-entered, even if the key is still there
+	if handle.released() {
+		err = ERROR_LOCK_RELEASED
+		return
+	}
+
+	for {
+		var keyPair *KVPair
+		var keyValue LockDetail
+		keyPair, keyValue, err = handle.readDetail()
+		if err != nil {
+			return
+		}
+		if keyValue.HoldCount < 1 {
+			err = ERROR_LOCK_RELEASED
+			return
+		}
+
+		// Write the new count, unless the lock changed meanwhile
+		keyValue.HoldCount += delta
+		var written bool
+		written, err = putDetail(context.Background(), handle.client, keyPair, keyValue)
+		if err != nil || written {
+			holds = keyValue.HoldCount
+			return
+		}
+	}
+}
+
+// readDetail reads the lock and its LockDetail, validating it is still held by the session and the owner of the handle.
+func (handle *LockHandle) readDetail() (keyPair *KVPair, keyValue LockDetail, err error) {
+	keyPair, err = handle.client.Get(context.Background(), handle.Key)
+	if err != nil {
+		return
+	}
+	if keyPair == nil {
+		err = ERROR_LOCK_RELEASED
+		return
+	}
+
+	err = json.Unmarshal(keyPair.Value, &keyValue)
+	if err != nil {
+		return
+	}
+	if keyValue.SessionID != handle.SessionID || keyValue.Owner != handle.owner {
+		err = ERROR_OCCUPY_BY_OTHER
+	}
+	return
+}
+
+// putDetail writes the LockDetail of the lock read as the pair, keeping the session holding it.
+// It writes only if the lock has not changed since it was read when the backend implements CASPutter,
+// so that a lock broken or taken meanwhile is not overwritten.
+func putDetail(ctx context.Context, client Backend, keyPair *KVPair, keyValue LockDetail) (written bool, err error) {
+	keyValue.Version = LOCK_DETAIL_VERSION
+	keyValue.UpdateTime = time.Now()
+	b, err := json.Marshal(keyValue)
+	if err != nil {
+		return
+	}
+	pair := &KVPair{
+		Key:         keyPair.Key,
+		Value:       b,
+		Session:     keyValue.SessionID,
+		ModifyIndex: keyPair.ModifyIndex,
+	}
+
+	if putter, ok := client.(CASPutter); ok {
+		return putter.PutCAS(ctx, pair)
+	}
+	err = client.Put(ctx, pair)
+	written = err == nil
+	return
+}
@@ -0,0 +1,153 @@
+package lockz
+
+import (
+	"context"
+)
+
+// RWLocker is the distributed read-write lock built on Locker.
+// Each RLock holds a contender key of its own "<key>/readers/<session>", and the writer holds "<key>/writer".
+// The writer takes its key first to stop new readers and then waits for the readers to drain,
+// while a reader takes its key first and backs off if it finds the writer.
+// Either one always sees the other, so a reader and the writer never hold the lock together.
+// (先占位，再看对方)
+type RWLocker struct {
+	locker Locker // Holds the contender keys, each with a session of its own
+}
+
+// NewRWLocker creates a read-write locker entity, the backend of the driver must implement Lister.
+func NewRWLocker(opts BasicOptions) (rwLocker RWLocker, err error) {
+	// Create the locker holding the contender keys
+	rwLocker.locker, err = NewLocker(opts)
+	return
+}
+
+// RLock retries until the shared lock is obtained, it waits while a writer holds or waits for the lock.
+// Every call reads with a handle of its own, which is given back to RUnlock.
+func (rwLocker *RWLocker) RLock(key string) (handle *LockHandle, err error) {
+	return rwLocker.RLockContext(context.Background(), key)
+}
+
+// RLockContext is the same as RLock, but gives up waiting and returns ctx.Err() once the context is done.
+func (rwLocker *RWLocker) RLockContext(ctx context.Context, key string) (handle *LockHandle, err error) {
+	for {
+		// Wait for the writer to leave
+		err = rwLocker.waitReleased(ctx, writerKey(key))
+		if err != nil {
+			return
+		}
+
+		// Take a reader key named after a session of its own, nobody else competes for it
+		err = rwLocker.locker.reEstablishClient()
+		if err != nil {
+			return
+		}
+		client, opts := rwLocker.locker.snapshot()
+		var sessionID string
+		sessionID, err = rwLocker.locker.createSession(ctx, client)
+		if err != nil {
+			return
+		}
+		handle, err = rwLocker.locker.tryLock(ctx, client, opts, sessionID, readersPrefix(key)+sessionID, LockDetail{Mode: MODE_READ})
+		if err != nil {
+			return
+		}
+
+		// Keep it if no writer came meanwhile
+		var keyPair *KVPair
+		keyPair, err = client.Get(ctx, writerKey(key))
+		if err == nil && keyPair == nil {
+			return
+		}
+
+		// Otherwise give way to the writer and try again
+		// (写者优先，读者让路)
+		_ = handle.Release()
+		handle = nil
+		if err != nil {
+			return
+		}
+	}
+}
+
+// RUnlock releases the shared lock held by the handle returned from RLock.
+func (rwLocker *RWLocker) RUnlock(handle *LockHandle) (err error) {
+	// Only a reader of this RWLocker still holding the lock is released
+	if handle == nil || rwLocker.locker.held.get(handle.Key) != handle {
+		err = ERROR_NOT_HELD
+		return
+	}
+	return handle.Release()
+}
+
+// Lock retries until the exclusive lock is obtained, and all the readers have released it.
+func (rwLocker *RWLocker) Lock(key string) (handle *LockHandle, err error) {
+	return rwLocker.LockContext(context.Background(), key)
+}
+
+// LockContext is the same as Lock, but gives up waiting and returns ctx.Err() once the context is done.
+func (rwLocker *RWLocker) LockContext(ctx context.Context, key string) (handle *LockHandle, err error) {
+	// The readers are found by listing their keys
+	client, _ := rwLocker.locker.snapshot()
+	lister, ok := client.(Lister)
+	if !ok {
+		err = ERROR_CANNOT_LIST
+		return
+	}
+
+	// Take the writer key, which stops new readers
+	handle, err = rwLocker.locker.lockContext(ctx, writerKey(key), LockDetail{Mode: MODE_WRITE})
+	if err != nil {
+		return
+	}
+
+	// Wait for the readers to drain, one by one
+	for {
+		var readers []*KVPair
+		readers, err = lister.List(ctx, readersPrefix(key))
+		if err == nil && len(readers) == 0 {
+			return
+		}
+		if err == nil {
+			err = rwLocker.waitReleased(ctx, readers[0].Key)
+		}
+		if err != nil {
+			_ = handle.Release()
+			handle = nil
+			return
+		}
+	}
+}
+
+// UnLock releases the exclusive lock held by this RWLocker.
+func (rwLocker *RWLocker) UnLock(key string) (err error) {
+	handle := rwLocker.locker.held.get(writerKey(key))
+	if handle == nil {
+		err = ERROR_NOT_HELD
+		return
+	}
+	return handle.Release()
+}
+
+// waitReleased blocks until the key is released, it returns nil at once if the key is free.
+func (rwLocker *RWLocker) waitReleased(ctx context.Context, key string) (err error) {
+	err = rwLocker.locker.BlockOnReleased(ctx, key)
+	if err == ERROR_LOCK_RELEASED {
+		err = nil
+	}
+	return
+}
+
+// Close closes the client of the RWLocker, release the locks first.
+func (rwLocker *RWLocker) Close() (err error) {
+	return rwLocker.locker.Close()
+}
+
+// readersPrefix is the prefix of the contender keys of the readers.
+func readersPrefix(key string) string {
+	return key + "/readers/"
+}
+
+// writerKey is the contender key of the writer.
+func writerKey(key string) string {
+	return key + "/writer"
+}
@@ -0,0 +1,85 @@
+package lockz
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_RWLocker confirms that readers share the lock, even through the same RWLocker, the writer waits for them to drain,
+// and the waiting writer keeps new readers out.
+func Test_Check_RWLocker(t *testing.T) {
+	// Create two readers and a writer
+	opts := BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+	}
+	reader0, err := NewRWLocker(opts)
+	require.NoError(t, err)
+	reader1, err := NewRWLocker(opts)
+	require.NoError(t, err)
+	writer, err := NewRWLocker(opts)
+	require.NoError(t, err)
+
+	// The readers share the lock
+	handle0, err := reader0.RLock("rw_locker_test")
+	require.NoError(t, err)
+	handle1, err := reader1.RLock("rw_locker_test")
+	require.NoError(t, err)
+	detail, err := handle0.Status()
+	require.NoError(t, err)
+	require.Equal(t, MODE_READ, detail.Mode)
+	again, err := reader0.RLock("rw_locker_test")
+	require.NoError(t, err)
+	require.NotEqual(t, handle0.Key, again.Key)
+	require.NoError(t, reader0.RUnlock(again))
+	require.Equal(t, ERROR_NOT_HELD, reader1.RUnlock(handle0))
+
+	// The writer waits for the readers in vain
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = writer.LockContext(ctx, "rw_locker_test")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The writer waits again, and a new reader is kept out meanwhile
+	locked := make(chan *LockHandle)
+	go func() {
+		handle, err := writer.Lock("rw_locker_test")
+		require.NoError(t, err)
+		locked <- handle
+	}()
+	require.Eventually(t, func() bool {
+		detail, err := readLockStatus(writer.locker.client, writerKey("rw_locker_test"), "", 0)
+		return err == ERROR_OCCUPY_BY_OTHER && detail.Mode == MODE_WRITE
+	}, 5*time.Second, 10*time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = reader0.RLockContext(ctx, "rw_locker_test")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The writer gets the lock once the readers drain
+	require.NoError(t, handle0.Release())
+	require.NoError(t, reader1.RUnlock(handle1))
+	require.Equal(t, ERROR_LOCK_RELEASED, handle1.Release())
+	var handle *LockHandle
+	select {
+	case handle = <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writer never gets the lock")
+	}
+	detail, err = handle.Status()
+	require.NoError(t, err)
+	require.Equal(t, MODE_WRITE, detail.Mode)
+
+	// A reader gets the lock once the writer leaves
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, writer.UnLock("rw_locker_test"))
+	}()
+	handle0, err = reader0.RLock("rw_locker_test")
+	require.NoError(t, err)
+	require.NoError(t, reader0.RUnlock(handle0))
+	require.Equal(t, ERROR_NOT_HELD, reader0.RUnlock(handle0))
+}
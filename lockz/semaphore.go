@@ -0,0 +1,286 @@
+package lockz
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Semaphore allows up to Limit holders of a named resource at the same time, following the semaphore recipe of Consul.
+// Each holder acquires a contender key "<name>/<session>" bound to a session of its own,
+// and takes a slot by adding its session to the lock record "<name>/.lock" with check-and-set.
+// A holder whose session died loses its contender key, and its slot is taken back by the next contender.
+// The backend of the driver must implement Lister and CASPutter.
+// (最多 N 个持有者)
+type Semaphore struct {
+	locker Locker // Holds the contender keys, each with a session of its own
+	name   string // The name of the resource
+	limit  int    // The number of the slots
+}
+
+// SemaphoreDetail is written into the lock record of the semaphore.
+type SemaphoreDetail struct {
+	Limit   int      `json:"limit"`
+	Holders []string `json:"holders"` // The sessions holding the slots
+}
+
+// NewSemaphore creates a semaphore entity of the resource with the number of the slots, at least one.
+func NewSemaphore(opts BasicOptions, name string, limit int) (semaphore Semaphore, err error) {
+	// Nobody could ever acquire a semaphore without slots
+	if limit < 1 {
+		err = ERROR_SEMAPHORE_SLOTS
+		return
+	}
+
+	semaphore.locker, err = NewLocker(opts)
+	semaphore.name = name
+	semaphore.limit = limit
+	return
+}
+
+// Acquire retries until a slot is obtained, the returned handle renews the slot with Extend.
+func (semaphore *Semaphore) Acquire() (handle *LockHandle, err error) {
+	return semaphore.AcquireContext(context.Background())
+}
+
+// AcquireContext is the same as Acquire, but gives up waiting and returns ctx.Err() once the context is done.
+func (semaphore *Semaphore) AcquireContext(ctx context.Context) (handle *LockHandle, err error) {
+	// The holders are found by listing their contender keys, and the slots are taken with check-and-set
+	err = semaphore.locker.reEstablishClient()
+	if err != nil {
+		return
+	}
+	client, opts := semaphore.locker.snapshot()
+	lister, listOK := client.(Lister)
+	putter, casOK := client.(CASPutter)
+	if !listOK {
+		err = ERROR_CANNOT_LIST
+		return
+	}
+	if !casOK {
+		err = ERROR_CANNOT_CAS
+		return
+	}
+
+	// Acquire the contender key with a session of its own
+	var sessionID string
+	sessionID, err = semaphore.locker.createSession(ctx, client)
+	if err != nil {
+		return
+	}
+	handle, err = semaphore.locker.tryLock(ctx, client, opts, sessionID, semaphore.prefix()+sessionID, LockDetail{Mode: MODE_SEMAPHORE})
+	if err != nil {
+		return
+	}
+
+	// The session must outlive the wait for a slot, the caller renews it afterwards
+	stop := semaphore.locker.keepSession(ctx, client, sessionID)
+	defer stop()
+
+	for {
+		// Take a slot if there is a free one, or wait for a change
+		var acquired bool
+		acquired, err = semaphore.takeSlot(ctx, lister, putter, handle)
+		if err == nil && acquired {
+			return
+		}
+
+		// Give up on errors, the contender key and its session are released
+		if err != nil {
+			_ = handle.Release()
+			handle = nil
+			return
+		}
+	}
+}
+
+// Release gives the slot back and releases the contender key of the handle.
+// Releasing the handle alone also frees the slot, but only when the next contender notices it.
+func (semaphore *Semaphore) Release(handle *LockHandle) (err error) {
+	client, _ := semaphore.locker.snapshot()
+	putter, ok := client.(CASPutter)
+	if !ok {
+		err = ERROR_CANNOT_CAS
+	}
+
+	// Remove the session from the lock record, retry if another holder changed it meanwhile
+	for ok {
+		var record *KVPair
+		var detail SemaphoreDetail
+		record, detail, err = semaphore.readRecord(context.Background(), client)
+		if err != nil || !detail.remove(handle.SessionID) {
+			break
+		}
+
+		var written bool
+		written, err = semaphore.writeRecord(context.Background(), putter, record, detail)
+		if err != nil || written {
+			break
+		}
+	}
+
+	// Release the contender key and the session anyway
+	releaseErr := handle.Release()
+	if err == nil {
+		err = releaseErr
+	}
+	return
+}
+
+// Close closes the client of the semaphore, release the slots first.
+func (semaphore *Semaphore) Close() (err error) {
+	return semaphore.locker.Close()
+}
+
+// takeSlot adds the session of the handle to the lock record if there is a free slot, the dead holders are pruned first.
+// It blocks until something changes if there is no free slot, and returns ERROR_SESSION_EXPIRED if the session of the handle died.
+func (semaphore *Semaphore) takeSlot(ctx context.Context, lister Lister, putter CASPutter, handle *LockHandle) (acquired bool, err error) {
+	// Find the alive holders by their contender keys
+	var pairs []*KVPair
+	pairs, err = lister.List(ctx, semaphore.prefix())
+	if err != nil {
+		return
+	}
+	alive := make(map[string]bool)
+	for _, pair := range pairs {
+		if pair.Session != "" && pair.Key == semaphore.prefix()+pair.Session {
+			alive[pair.Session] = true
+		}
+	}
+
+	// A dead contender must not take a slot
+	if !alive[handle.SessionID] {
+		err = ERROR_SESSION_EXPIRED
+		return
+	}
+
+	// Read the lock record
+	var record *KVPair
+	var detail SemaphoreDetail
+	record, detail, err = semaphore.readRecord(ctx, handle.client)
+	if err != nil {
+		return
+	}
+
+	// Prune the holders whose sessions died
+	// (持有者死了，名额收回)
+	holders := detail.Holders[:0]
+	for _, holder := range detail.Holders {
+		if alive[holder] {
+			holders = append(holders, holder)
+		}
+	}
+	detail.Holders = holders
+
+	// No free slot, wait for a holder to leave
+	if len(detail.Holders) >= detail.Limit {
+		err = semaphore.waitChange(ctx, handle.client, record, detail.Holders)
+		return
+	}
+
+	// Take the free slot, another contender may have changed the record meanwhile
+	detail.Holders = append(detail.Holders, handle.SessionID)
+	acquired, err = semaphore.writeRecord(ctx, putter, record, detail)
+	return
+}
+
+// waitChange blocks until the lock record changes or the contender key of a holder is released.
+func (semaphore *Semaphore) waitChange(ctx context.Context, client Backend, record *KVPair, holders []string) (err error) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Watch every key, the first change wakes up
+	changed := make(chan error, len(holders)+1)
+	go semaphore.watchKey(watchCtx, client, semaphore.recordKey(), changed, func(pair *KVPair) bool {
+		return modifyIndexOf(pair) != modifyIndexOf(record)
+	})
+	for _, holder := range holders {
+		go semaphore.watchKey(watchCtx, client, semaphore.prefix()+holder, changed, func(pair *KVPair) bool {
+			return pair == nil
+		})
+	}
+
+	select {
+	case err = <-changed:
+	case <-ctx.Done():
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return
+}
+
+// watchKey reports to changed once the key changes, or at once if it has already changed from what was read.
+func (semaphore *Semaphore) watchKey(ctx context.Context, client Backend, key string, changed chan<- error, hasChanged func(pair *KVPair) bool) {
+	var waitIndex uint64
+	for {
+		pair, lastIndex, err := client.Watch(ctx, key, waitIndex)
+		if err != nil || waitIndex != 0 || hasChanged(pair) {
+			changed <- err
+			return
+		}
+		waitIndex = lastIndex
+	}
+}
+
+// readRecord reads the lock record, a missing record has no holders and the limit of this semaphore.
+func (semaphore *Semaphore) readRecord(ctx context.Context, client Backend) (record *KVPair, detail SemaphoreDetail, err error) {
+	detail.Limit = semaphore.limit
+	record, err = client.Get(ctx, semaphore.recordKey())
+	if err != nil || record == nil {
+		return
+	}
+
+	err = json.Unmarshal(record.Value, &detail)
+	if err != nil {
+		return
+	}
+
+	// All holders must agree on the limit
+	if detail.Limit != semaphore.limit {
+		err = ERROR_SEMAPHORE_LIMIT
+	}
+	return
+}
+
+// writeRecord writes the lock record, only if it has not been changed since it was read.
+func (semaphore *Semaphore) writeRecord(ctx context.Context, putter CASPutter, record *KVPair, detail SemaphoreDetail) (written bool, err error) {
+	b, err := json.Marshal(detail)
+	if err != nil {
+		return
+	}
+	return putter.PutCAS(ctx, &KVPair{
+		Key:         semaphore.recordKey(),
+		Value:       b,
+		ModifyIndex: modifyIndexOf(record),
+	})
+}
+
+// remove removes the session from the holders, and tells whether it was there.
+func (detail *SemaphoreDetail) remove(sessionID string) (removed bool) {
+	for i, holder := range detail.Holders {
+		if holder == sessionID {
+			detail.Holders = append(detail.Holders[:i], detail.Holders[i+1:]...)
+			removed = true
+			return
+		}
+	}
+	return
+}
+
+// prefix is the prefix of the contender keys and the lock record.
+func (semaphore *Semaphore) prefix() string {
+	return semaphore.name + "/"
+}
+
+// recordKey is the key of the lock record.
+func (semaphore *Semaphore) recordKey() string {
+	return semaphore.prefix() + ".lock"
+}
+
+// modifyIndexOf is the modify index of the pair, zero if it does not exist.
+func modifyIndexOf(pair *KVPair) uint64 {
+	if pair == nil {
+		return 0
+	}
+	return pair.ModifyIndex
+}
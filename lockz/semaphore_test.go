@@ -0,0 +1,127 @@
+package lockz
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_Semaphore confirms that at most two holders get the slots, a released slot is taken by the waiting contender,
+// and the slot of a dead holder is taken back.
+func Test_Check_Semaphore(t *testing.T) {
+	// Create the semaphore with two slots
+	semaphore, err := NewSemaphore(BasicOptions{
+		Driver:      "memory",
+		SessionTTL:  10 * time.Second,
+		ExtendLimit: 10,
+	}, "semaphore_test", 2)
+	require.NoError(t, err)
+
+	// Two holders get the slots, and renew them as a lock
+	handle0, err := semaphore.Acquire()
+	require.NoError(t, err)
+	handle1, err := semaphore.Acquire()
+	require.NoError(t, err)
+	require.NoError(t, handle0.Incr())
+	detail, err := handle0.Status()
+	require.NoError(t, err)
+	require.Equal(t, MODE_SEMAPHORE, detail.Mode)
+	require.Equal(t, 1, detail.Extend)
+
+	// The third one waits in vain, and leaves nothing behind
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = semaphore.AcquireContext(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	pairs, err := semaphore.locker.client.(Lister).List(context.Background(), "semaphore_test/")
+	require.NoError(t, err)
+	require.Len(t, pairs, 3)
+
+	// The released slot is taken by the waiting contender
+	acquired := make(chan *LockHandle)
+	go func() {
+		handle, err := semaphore.Acquire()
+		require.NoError(t, err)
+		acquired <- handle
+	}()
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, semaphore.Release(handle0))
+	var handle2 *LockHandle
+	select {
+	case handle2 = <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the released slot is never taken")
+	}
+
+	// The slot of a dead holder is taken back
+	go func() {
+		handle, err := semaphore.Acquire()
+		require.NoError(t, err)
+		acquired <- handle
+	}()
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, semaphore.locker.client.DestroySession(context.Background(), handle1.SessionID))
+	var handle3 *LockHandle
+	select {
+	case handle3 = <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slot of the dead holder is never taken back")
+	}
+	_, record, err := semaphore.readRecord(context.Background(), semaphore.locker.client)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{handle2.SessionID, handle3.SessionID}, record.Holders)
+
+	// All holders must agree on the limit
+	other, err := NewSemaphore(BasicOptions{Driver: "memory", SessionTTL: 10 * time.Second}, "semaphore_test", 3)
+	require.NoError(t, err)
+	_, err = other.Acquire()
+	require.Equal(t, ERROR_SEMAPHORE_LIMIT, err)
+
+	// A semaphore without slots is refused
+	_, err = NewSemaphore(BasicOptions{Driver: "memory", SessionTTL: 10 * time.Second}, "semaphore_test", 0)
+	require.Equal(t, ERROR_SEMAPHORE_SLOTS, err)
+
+	// Everything is cleaned up after the release
+	require.NoError(t, semaphore.Release(handle2))
+	require.NoError(t, semaphore.Release(handle3))
+	_, record, err = semaphore.readRecord(context.Background(), semaphore.locker.client)
+	require.NoError(t, err)
+	require.Empty(t, record.Holders)
+}
+
+// Test_Check_SemaphoreWait confirms that the session of a contender outlives a wait longer than its TTL.
+func Test_Check_SemaphoreWait(t *testing.T) {
+	semaphore, err := NewSemaphore(BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   time.Second,
+		ExtendPeriod: 200 * time.Millisecond,
+		ExtendLimit:  100,
+	}, "semaphore_wait_test", 1)
+	require.NoError(t, err)
+	holder, err := semaphore.Acquire()
+	require.NoError(t, err)
+	go func() {
+		_ = holder.Extend()
+	}()
+
+	// The contender waits longer than the TTL of its session
+	acquired := make(chan *LockHandle)
+	go func() {
+		handle, err := semaphore.Acquire()
+		require.NoError(t, err)
+		acquired <- handle
+	}()
+	time.Sleep(2500 * time.Millisecond)
+	require.NoError(t, semaphore.Release(holder))
+
+	// The slot is taken by an alive session
+	var handle *LockHandle
+	select {
+	case handle = <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the released slot is never taken")
+	}
+	require.NoError(t, semaphore.locker.client.RenewSession(context.Background(), handle.SessionID))
+	require.NoError(t, semaphore.Release(handle))
+}
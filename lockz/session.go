@@ -1,44 +1,129 @@
 package lockz
 
 import (
-	"github.com/hashicorp/consul/api"
+	"context"
 	"strconv"
+	"time"
 )
 
-// NewSession creates a new session of locker.
-func (locker *Locker) NewSession() (err error) {
-	// Destroy any existing session
-	_ = locker.DestroySession()
+// NewSession creates a new session of locker, each acquired lock owns a session of its own.
+func (locker *Locker) NewSession() (sessionID string, err error) {
+	client, _ := locker.snapshot()
+	return locker.createSession(context.Background(), client)
+}
+
+// createSession creates a new session on the client, traced as a child of the context, which aborts it as well.
+func (locker *Locker) createSession(ctx context.Context, client Backend) (sessionID string, err error) {
+	// Read the session TTL and the lock delay
+	locker.mutex.RLock()
+	sessionTTL := locker.sessionTTL
+	opts := locker.Opts.Basic
+	locker.mutex.RUnlock()
+
+	ctx, span := opts.startSpan(ctx, "lockz.CreateSession")
+	defer func() {
+		span.SetAttributes(ATTRIBUTE_SESSION_ID.String(sessionID))
+		endSpan(span, err)
+		if err != nil {
+			opts.logger().Warn("lockz session not created", "error", err)
+			return
+		}
+		opts.logger().Debug("lockz session created", "session_id", sessionID, "ttl", sessionTTL)
+	}()
+
+	// Parse the session TTL
+	ttl, err := time.ParseDuration(sessionTTL)
+	if err != nil {
+		return
+	}
 
 	// Define the session options
-	sessionOpts := &api.SessionEntry{
+	sessionOpts := SessionEntry{
 		Name:      "consensusLockz",
 		Behavior:  "delete",
-		TTL:       locker.sessionTTL,
-		LockDelay: locker.Opts.Basic.LockDelay,
+		TTL:       ttl,
+		LockDelay: opts.LockDelay,
 	}
 
 	// Create a new session
-	locker.sessionID, _, err = locker.client.Session().Create(sessionOpts, nil)
+	sessionID, err = client.CreateSession(ctx, sessionOpts)
 	if err != nil {
-		return err
+		return
 	}
 
 	// Return no error if session created successfully
 	return
 }
 
-// DestroySession deletes the associated session and resources.
-func (locker *Locker) DestroySession() (err error) {
-	if locker.sessionID != "" {
-		_, err = locker.client.Session().Destroy(locker.sessionID, nil)
-		locker.sessionID = ""
+// DestroySession deletes the session and resources.
+func (locker *Locker) DestroySession(sessionID string) (err error) {
+	if sessionID != "" {
+		client, opts := locker.snapshot()
+		err = destroySession(context.Background(), client, opts, sessionID)
+	}
+	return
+}
+
+// destroySession destroys the session on the client, traced as a child of the context, which aborts it as well.
+func destroySession(ctx context.Context, client Backend, opts BasicOptions, sessionID string) (err error) {
+	ctx, span := opts.startSpan(ctx, "lockz.DestroySession", ATTRIBUTE_SESSION_ID.String(sessionID))
+	defer func() {
+		endSpan(span, err)
+		if err != nil {
+			opts.logger().Warn("lockz session not destroyed", "session_id", sessionID, "error", err)
+			return
+		}
+		opts.logger().Debug("lockz session destroyed", "session_id", sessionID)
+	}()
+
+	return client.DestroySession(ctx, sessionID)
+}
+
+// keepSession renews the session every half of its TTL until the returned function is called,
+// so that a session created before a wait outlives it. A session lost meanwhile is found by the waiter.
+// (排队时别让会话过期)
+func (locker *Locker) keepSession(ctx context.Context, client Backend, sessionID string) (stop func()) {
+	locker.mutex.RLock()
+	sessionTTL := locker.sessionTTL
+	locker.mutex.RUnlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	stop = func() {
+		cancel()
+		<-done
 	}
+
+	// A session without TTL never expires
+	ttl, err := time.ParseDuration(sessionTTL)
+	if err != nil || ttl <= 0 {
+		close(done)
+		return
+	}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if client.RenewSession(ctx, sessionID) == ERROR_SESSION_EXPIRED {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 	return
 }
 
 // ReloadSessionTTL reloads the time-to-live (TTL) value for a session in a locker.
 func (locker *Locker) ReloadSessionTTL() (err error) {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
 	if locker.Opts.Basic.SessionTTL == 0 {
 		locker.sessionTTL = DEFAULT_SESSION_TIMEOUT
 	} else {
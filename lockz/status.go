@@ -0,0 +1,188 @@
+package lockz
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Status is the state of a lock in the lifecycle of the locker.
+type Status uint32
+
+// The states of the lock, each key locked by the locker goes through them:
+//
+//	idle -> waiting -> competing -> locked -> extending -> extend-limit-reached -> lost or released
+//
+// A key released or lost is forgotten and starts again from idle.
+// (每把锁走自己的状态机)
+const (
+	STATUS_IDLE                 Status = iota + 1 // Nothing is done with the key
+	STATUS_WAITING                                // Waiting for the holder to release the lock
+	STATUS_COMPETING                              // Trying to acquire the lock
+	STATUS_LOCKED                                 // The lock is held
+	STATUS_EXTENDING                              // The lock is held and renewed
+	STATUS_EXTEND_LIMIT_REACHED                   // The lock is held, but it cannot be renewed anymore
+	STATUS_LOST                                   // The lock was lost before the release
+	STATUS_RELEASED                               // The lock was released
+)
+
+// String returns the name of the status.
+func (status Status) String() string {
+	switch status {
+	case STATUS_IDLE:
+		return "idle"
+	case STATUS_WAITING:
+		return "waiting"
+	case STATUS_COMPETING:
+		return "competing"
+	case STATUS_LOCKED:
+		return "locked"
+	case STATUS_EXTENDING:
+		return "extending"
+	case STATUS_EXTEND_LIMIT_REACHED:
+		return "extend-limit-reached"
+	case STATUS_LOST:
+		return "lost"
+	case STATUS_RELEASED:
+		return "released"
+	default:
+		return "unknown"
+	}
+}
+
+// transitions lists the states which each state may change to, the others are rejected.
+// A failed attempt goes back to idle, so that waiting again always starts from there.
+var transitions = map[Status][]Status{
+	STATUS_IDLE:                 {STATUS_WAITING, STATUS_COMPETING},
+	STATUS_WAITING:              {STATUS_COMPETING, STATUS_IDLE},
+	STATUS_COMPETING:            {STATUS_LOCKED, STATUS_IDLE},
+	STATUS_LOCKED:               {STATUS_EXTENDING, STATUS_EXTEND_LIMIT_REACHED, STATUS_LOST, STATUS_RELEASED},
+	STATUS_EXTENDING:            {STATUS_EXTEND_LIMIT_REACHED, STATUS_LOST, STATUS_RELEASED},
+	STATUS_EXTEND_LIMIT_REACHED: {STATUS_LOST, STATUS_RELEASED},
+}
+
+// canTransit tells whether the status may change from one state to the other.
+func canTransit(from Status, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// STATUS_EVENT_BUFFER is the number of the events kept for a slow subscriber, the later ones are dropped.
+const STATUS_EVENT_BUFFER = 64
+
+// StatusEvent is sent to the subscribers every time the status of a key changes.
+type StatusEvent struct {
+	Key  string    // The key of the lock
+	From Status    // The status before the change
+	To   Status    // The status after the change
+	At   time.Time // When the status changed
+}
+
+// statusMachine keeps the status of each key locked by a locker, and the latest status of the locker.
+// It is shared by the copies of the locker and by the handles.
+type statusMachine struct {
+	mutex       sync.Mutex
+	latest      Status                        // The status after the latest change of any key
+	keys        map[string]Status             // The keys which are not idle
+	subscribers map[chan StatusEvent]struct{} // The channels returned by Transitions
+}
+
+// newStatusMachine creates the machine of an idle locker.
+func newStatusMachine() *statusMachine {
+	return &statusMachine{
+		latest:      STATUS_IDLE,
+		keys:        make(map[string]Status),
+		subscribers: make(map[chan StatusEvent]struct{}),
+	}
+}
+
+// transit changes the status of the key and tells the subscribers, it returns false if the change is not allowed.
+// Changing to the same status does nothing.
+func (machine *statusMachine) transit(opts BasicOptions, key string, to Status) (ok bool) {
+	machine.mutex.Lock()
+	defer machine.mutex.Unlock()
+
+	from, found := machine.keys[key]
+	if !found {
+		from = STATUS_IDLE
+	}
+	if from == to {
+		return true
+	}
+	if !canTransit(from, to) {
+		opts.logger().Debug("lockz status change rejected", "key", key, "from", from.String(), "to", to.String())
+		return false
+	}
+
+	// Forget the keys which are done with
+	switch to {
+	case STATUS_IDLE, STATUS_LOST, STATUS_RELEASED:
+		delete(machine.keys, key)
+	default:
+		machine.keys[key] = to
+	}
+	machine.latest = to
+	opts.logger().Debug("lockz status changed", "key", key, "from", from.String(), "to", to.String())
+
+	// The lock operations never wait for the subscribers
+	event := StatusEvent{Key: key, From: from, To: to, At: time.Now()}
+	for events := range machine.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+	return true
+}
+
+// transit changes the status of the key locked by the locker.
+func (locker *Locker) transit(key string, to Status) {
+	_, opts := locker.snapshot()
+	locker.status.transit(opts, key, to)
+}
+
+// Status returns the status after the latest change of any key locked by the locker.
+// A locker holding several keys at once tells them apart with StatusOf or Transitions.
+func (locker *Locker) Status() Status {
+	locker.status.mutex.Lock()
+	defer locker.status.mutex.Unlock()
+
+	return locker.status.latest
+}
+
+// StatusOf returns the status of the key locked by the locker, STATUS_IDLE if nothing is done with it.
+func (locker *Locker) StatusOf(key string) Status {
+	locker.status.mutex.Lock()
+	defer locker.status.mutex.Unlock()
+
+	status, found := locker.status.keys[key]
+	if !found {
+		status = STATUS_IDLE
+	}
+	return status
+}
+
+// Transitions streams the changes of the status of every key locked by the locker from now on.
+// The events are dropped if the receiver falls STATUS_EVENT_BUFFER events behind. The channel is closed once the context is done.
+// (订阅状态变化)
+func (locker *Locker) Transitions(ctx context.Context) <-chan StatusEvent {
+	machine := locker.status
+	events := make(chan StatusEvent, STATUS_EVENT_BUFFER)
+
+	machine.mutex.Lock()
+	machine.subscribers[events] = struct{}{}
+	machine.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		machine.mutex.Lock()
+		defer machine.mutex.Unlock()
+		delete(machine.subscribers, events)
+		close(events)
+	}()
+	return events
+}
@@ -0,0 +1,81 @@
+package lockz
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Test_Check_Status confirms that the status of the keys follows the state machine, and the changes are streamed.
+func Test_Check_Status(t *testing.T) {
+	opts := BasicOptions{
+		Driver:       "memory",
+		SessionTTL:   10 * time.Second,
+		ExtendPeriod: 20 * time.Millisecond,
+		ExtendLimit:  2,
+	}
+	holder, err := NewLocker(opts)
+	require.NoError(t, err)
+	locker, err := NewLocker(opts)
+	require.NoError(t, err)
+	require.Equal(t, STATUS_IDLE, locker.Status())
+	require.Equal(t, "extend-limit-reached", STATUS_EXTEND_LIMIT_REACHED.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := locker.Transitions(ctx)
+	next := func() (changed string) {
+		select {
+		case event := <-events:
+			require.Equal(t, "status_test", event.Key)
+			changed = event.From.String() + " -> " + event.To.String()
+		case <-time.After(time.Second):
+			t.Fatal("no status change")
+		}
+		return
+	}
+
+	// Wait for the holder, then give up
+	held, err := holder.Lock("status_test")
+	require.NoError(t, err)
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = locker.LockContext(waitCtx, "status_test")
+	waitCancel()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, "idle -> waiting", next())
+	require.Equal(t, "waiting -> idle", next())
+	require.NoError(t, held.Release())
+
+	// Lock, renew until the limit and lose the lock
+	handle, err := locker.Lock("status_test")
+	require.NoError(t, err)
+	require.Equal(t, STATUS_LOCKED, locker.StatusOf("status_test"))
+	require.Error(t, handle.Extend())
+	require.Equal(t, "idle -> competing", next())
+	require.Equal(t, "competing -> locked", next())
+	require.Equal(t, "locked -> extending", next())
+	require.Equal(t, "extending -> extend-limit-reached", next())
+	require.Equal(t, "extend-limit-reached -> lost", next())
+	require.Equal(t, STATUS_LOST, locker.Status())
+	require.Equal(t, STATUS_IDLE, locker.StatusOf("status_test"))
+	require.NoError(t, handle.Release())
+
+	// Lock and release, the key starts again from idle
+	handle, err = locker.TryLockOnce("status_test")
+	require.NoError(t, err)
+	require.NoError(t, handle.Release())
+	require.Equal(t, "idle -> competing", next())
+	require.Equal(t, "competing -> locked", next())
+	require.Equal(t, "locked -> released", next())
+	require.Equal(t, STATUS_RELEASED, locker.Status())
+
+	// The changes out of the state machine are rejected
+	require.False(t, locker.status.transit(opts, "status_test", STATUS_RELEASED))
+	require.False(t, locker.status.transit(opts, "status_test", STATUS_LOCKED))
+
+	// The channel is closed once the context is done
+	cancel()
+	_, open := <-events
+	require.False(t, open)
+}
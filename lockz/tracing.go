@@ -0,0 +1,46 @@
+package lockz
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// The lock operations emit OpenTelemetry spans through BasicOptions.TracerProvider.
+// The spans started by LockContext, BlockOnReleased, ExtendContext and UnLockContext are children of the span in the context,
+// so that the waits for the locks show up inside the request traces. Nothing is traced if the provider is nil.
+// (锁等多久，链路上看得到)
+
+// TRACER_NAME is the name of the tracer of this package.
+const TRACER_NAME = "github.com/panhongrainbow/consensusLockz/lockz"
+
+// The attributes of the spans.
+const (
+	ATTRIBUTE_KEY        = attribute.Key("lockz.key")
+	ATTRIBUTE_SESSION_ID = attribute.Key("lockz.session_id")
+	ATTRIBUTE_EXTEND     = attribute.Key("lockz.extend")
+	ATTRIBUTE_OUTCOME    = attribute.Key("lockz.outcome") // ErrorLabel of the result
+)
+
+// nopTracer is used when no provider is set.
+var nopTracer = noop.NewTracerProvider().Tracer(TRACER_NAME)
+
+// startSpan starts the span of the operation as a child of the span in the context.
+func (opts BasicOptions) startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := nopTracer
+	if opts.TracerProvider != nil {
+		tracer = opts.TracerProvider.Tracer(TRACER_NAME)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span with the outcome of the operation.
+func endSpan(span trace.Span, err error) {
+	span.SetAttributes(ATTRIBUTE_OUTCOME.String(ErrorLabel(err)))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
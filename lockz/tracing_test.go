@@ -0,0 +1,71 @@
+package lockz
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+	"time"
+)
+
+// Test_Check_Tracing confirms that the lock operations are traced inside the span of the caller.
+func Test_Check_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	opts := BasicOptions{
+		Driver:         "memory",
+		SessionTTL:     10 * time.Second,
+		ExtendLimit:    10,
+		TracerProvider: provider,
+	}
+	holder, err := NewLocker(opts)
+	require.NoError(t, err)
+	locker, err := NewLocker(opts)
+	require.NoError(t, err)
+
+	// The lock is held by another locker for a while
+	held, err := holder.Lock("tracing_test")
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = held.Release()
+	}()
+	exporter.Reset()
+
+	// Lock inside the span of a request
+	ctx, request := provider.Tracer("test").Start(context.Background(), "request")
+	handle, err := locker.LockContext(ctx, "tracing_test")
+	require.NoError(t, err)
+	require.NoError(t, handle.Incr())
+	_, err = locker.UnLockContext(ctx, "tracing_test")
+	require.NoError(t, err)
+	request.End()
+
+	// The wait, the session and the attempt are children of the lock, which is a child of the request,
+	// the last span of each name is the one of this locker
+	spans := exporter.GetSpans()
+	find := func(name string) (found tracetest.SpanStub) {
+		for _, span := range spans {
+			if span.Name == name {
+				found = span
+			}
+		}
+		require.Equal(t, name, found.Name)
+		return
+	}
+	lock := find("lockz.Lock")
+	require.Equal(t, request.SpanContext().SpanID(), lock.Parent.SpanID())
+	for _, name := range []string{"lockz.BlockOnReleased", "lockz.CreateSession", "lockz.TryLock"} {
+		require.Equal(t, lock.SpanContext.SpanID(), find(name).Parent.SpanID(), name)
+	}
+	require.Contains(t, find("lockz.BlockOnReleased").Attributes, ATTRIBUTE_OUTCOME.String("ok"))
+	require.Contains(t, lock.Attributes, ATTRIBUTE_KEY.String("tracing_test"))
+	require.Contains(t, lock.Attributes, ATTRIBUTE_SESSION_ID.String(handle.SessionID))
+
+	// The renewal counts the extension, and the release destroys the session inside the unlock
+	require.Contains(t, find("lockz.Incr").Attributes, ATTRIBUTE_EXTEND.Int(1))
+	unlock := find("lockz.UnLock")
+	require.Equal(t, request.SpanContext().SpanID(), unlock.Parent.SpanID())
+	require.Equal(t, unlock.SpanContext.SpanID(), find("lockz.DestroySession").Parent.SpanID())
+}